@@ -0,0 +1 @@
+ALTER TABLE `spolls_email_outbox` DROP COLUMN text_body;
\ No newline at end of file
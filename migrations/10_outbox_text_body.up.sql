@@ -0,0 +1 @@
+ALTER TABLE `spolls_email_outbox` ADD COLUMN text_body MEDIUMTEXT NULL DEFAULT NULL AFTER body;
\ No newline at end of file
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `spolls_email_optouts`;
+
+ALTER TABLE `spolls_email_outbox`
+    DROP INDEX ix_outbox_source_ip_create_date,
+    DROP INDEX ix_outbox_recipient_create_date,
+    DROP COLUMN source_ip;
@@ -0,0 +1,9 @@
+ALTER TABLE `spolls_email_outbox`
+    ADD COLUMN source_ip VARCHAR(45) NULL DEFAULT NULL AFTER recipient,
+    ADD INDEX ix_outbox_recipient_create_date (recipient, create_date),
+    ADD INDEX ix_outbox_source_ip_create_date (source_ip, create_date);
+
+CREATE TABLE IF NOT EXISTS `spolls_email_optouts` (
+    email VARCHAR(128) NOT NULL PRIMARY KEY,
+    create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
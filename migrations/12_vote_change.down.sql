@@ -0,0 +1 @@
+ALTER TABLE `spolls_votes` DROP COLUMN is_change;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `spolls_votes` ADD COLUMN is_change BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `spolls_options` DROP COLUMN position;ALTER TABLE `spolls_extras` DROP COLUMN position;
\ No newline at end of file
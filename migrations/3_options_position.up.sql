@@ -0,0 +1 @@
+ALTER TABLE `spolls_options` ADD COLUMN position INT NOT NULL DEFAULT 0;ALTER TABLE `spolls_extras` ADD COLUMN position INT NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `spolls_polls` DROP COLUMN opens_at;ALTER TABLE `spolls_polls` DROP COLUMN closes_at;
\ No newline at end of file
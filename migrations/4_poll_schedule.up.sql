@@ -0,0 +1 @@
+ALTER TABLE `spolls_polls` ADD COLUMN opens_at TIMESTAMP NULL DEFAULT NULL;ALTER TABLE `spolls_polls` ADD COLUMN closes_at TIMESTAMP NULL DEFAULT NULL;
\ No newline at end of file
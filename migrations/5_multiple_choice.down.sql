@@ -0,0 +1,6 @@
+ALTER TABLE `spolls_polls`
+    DROP COLUMN voting_mode,
+    DROP COLUMN min_selections,
+    DROP COLUMN max_selections;
+
+DROP TABLE IF EXISTS `spolls_vote_selections`;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS `spolls_vote_selections` (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    vote_id INT NOT NULL,
+    option_id INT NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+UNIQUE INDEX ux_selections_vote_opt (vote_id, option_id),
+INDEX fk_selections_opt_ix (option_id),
+FOREIGN KEY fk_selections_vote_ix(vote_id)
+    REFERENCES `spolls_votes`(id)
+        ON DELETE CASCADE
+        ON UPDATE CASCADE,
+FOREIGN KEY fk_selections_opt_ix(option_id)
+    REFERENCES `spolls_options`(id)
+        ON DELETE CASCADE
+        ON UPDATE CASCADE
+);
+
+INSERT INTO `spolls_vote_selections` (vote_id, option_id) SELECT id, option_id FROM `spolls_votes`;
+
+ALTER TABLE `spolls_polls`
+    ADD COLUMN voting_mode VARCHAR(16) NOT NULL DEFAULT 'single',
+    ADD COLUMN min_selections INT NOT NULL DEFAULT 1,
+    ADD COLUMN max_selections INT NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE `spolls_polls` DROP COLUMN seats;
\ No newline at end of file
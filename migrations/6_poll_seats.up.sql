@@ -0,0 +1 @@
+ALTER TABLE `spolls_polls` ADD COLUMN seats INT NOT NULL DEFAULT 1;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE `spolls_vote_selections` DROP COLUMN score;
+
+ALTER TABLE `spolls_polls`
+    DROP COLUMN score_min,
+    DROP COLUMN score_max;
@@ -0,0 +1,5 @@
+ALTER TABLE `spolls_polls`
+    ADD COLUMN score_min INT NOT NULL DEFAULT 1,
+    ADD COLUMN score_max INT NOT NULL DEFAULT 5;
+
+ALTER TABLE `spolls_vote_selections` ADD COLUMN score INT NULL DEFAULT NULL;
@@ -0,0 +1 @@
+ALTER TABLE `spolls_confirmations` DROP COLUMN purpose;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `spolls_confirmations` ADD COLUMN purpose VARCHAR(16) NOT NULL DEFAULT 'vote';
\ No newline at end of file
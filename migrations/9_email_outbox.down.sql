@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `spolls_email_outbox`;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS `spolls_email_outbox` (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    recipient VARCHAR(128) NOT NULL,
+    subject VARCHAR(512) NOT NULL,
+    body MEDIUMTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error VARCHAR(1024) NOT NULL DEFAULT '',
+    claim_id VARCHAR(64) NULL DEFAULT NULL,
+    claimed_at TIMESTAMP NULL DEFAULT NULL,
+    create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP NULL DEFAULT NULL,
+INDEX ix_outbox_status_next_attempt (status, next_attempt_at),
+INDEX ix_outbox_claim_id (claim_id)
+);
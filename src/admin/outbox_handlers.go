@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"switch-polls-backend/config"
+	"switch-polls-backend/db"
+	"switch-polls-backend/polls"
+	"switch-polls-backend/utils"
+)
+
+const maxOutboxListSize = 500
+
+type OutboxResponse struct {
+	Counts map[db.OutboxStatus]int `json:"counts"`
+	Emails []db.OutboxEmail        `json:"emails"`
+}
+
+// OutboxHandler lists the emails waiting for delivery and the ones that failed permanently.
+// The statuses can be narrowed down with the 'status' query parameter (pending, sending or dead).
+func OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := polls.LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Admin.OutboxEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("OutboxHandler failed to read request body: %v", err)
+		return
+	}
+
+	statuses := []db.OutboxStatus{db.OutboxStatusPending, db.OutboxStatusSending, db.OutboxStatusDead}
+	if s := r.URL.Query().Get("status"); s != "" {
+		status := db.OutboxStatus(s)
+		if status != db.OutboxStatusPending && status != db.OutboxStatusSending && status != db.OutboxStatusDead {
+			polls.WriteBadRequestResponse(&w)
+			return
+		}
+		statuses = []db.OutboxStatus{status}
+	}
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxOutboxListSize {
+			polls.WriteBadRequestResponse(&w)
+			return
+		}
+	}
+
+	var res OutboxResponse
+	if res.Counts, err = db.OutboxRepo.CountByStatus(); err != nil {
+		log.Printf("OutboxHandler cannot count the emails: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if res.Emails, err = db.OutboxRepo.List(statuses, limit); err != nil {
+		log.Printf("OutboxHandler cannot list the emails: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp, _ := utils.PrepareResponse(res)
+	w.Write(resp)
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strconv"
+	"switch-polls-backend/config"
+	"switch-polls-backend/db"
+	"switch-polls-backend/polls"
+	"switch-polls-backend/utils"
+)
+
+// CreatePollHandler creates a poll together with its options and extras. The order of
+// the options and extras in the request determines their order in the poll.
+func CreatePollHandler(w http.ResponseWriter, r *http.Request) {
+	poll, ok := readPoll(w, r)
+	if !ok {
+		return
+	}
+	poll.Id = 0
+	for i := range poll.Options {
+		poll.Options[i].Id = 0
+	}
+
+	res, err := db.PollsRepo.CreatePoll(poll)
+	if err != nil {
+		log.Printf("CreatePollHandler cannot create the poll: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("CreatePollHandler created poll %d", res.Id)
+	resp, _ := utils.PrepareResponse(res)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+}
+
+// UpdatePollHandler replaces the whole poll structure. Options are matched by their ids, options without
+// an id are created and the options missing from the request are deleted.
+func UpdatePollHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		polls.WriteBadRequestResponse(&w)
+		return
+	}
+	poll, ok := readPoll(w, r)
+	if !ok {
+		return
+	}
+	poll.Id = id
+
+	res, err := db.PollsRepo.UpdatePoll(poll)
+	if err != nil {
+		log.Printf("UpdatePollHandler cannot update poll %d: %v", id, err)
+		writeRepositoryError(w, err)
+		return
+	}
+	log.Printf("UpdatePollHandler updated poll %d", res.Id)
+	resp, _ := utils.PrepareResponse(res)
+	w.Write(resp)
+}
+
+func DeletePollHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		polls.WriteBadRequestResponse(&w)
+		return
+	}
+
+	err = db.PollsRepo.DeletePoll(id)
+	if err != nil {
+		log.Printf("DeletePollHandler cannot delete poll %d: %v", id, err)
+		writeRepositoryError(w, err)
+		return
+	}
+	log.Printf("DeletePollHandler deleted poll %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readPoll(w http.ResponseWriter, r *http.Request) (db.Poll, bool) {
+	var poll db.Poll
+	body, err := polls.LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Admin.PollsEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("admin failed to read request body: %v", err)
+		return poll, false
+	}
+	if err = json.Unmarshal(body, &poll); err != nil {
+		log.Printf("admin failed to unmarshal the poll: %v", err)
+		polls.WriteBadRequestResponse(&w)
+		return poll, false
+	}
+	NormalizePoll(&poll)
+	if err = ValidatePoll(poll); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		resp, _ := utils.PrepareResponse(err.Error())
+		w.Write(resp)
+		return poll, false
+	}
+	return poll, true
+}
+
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	var status int
+	switch {
+	case errors.Is(err, db.ErrPollNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, db.ErrPollHasConfirmedVotes):
+		status = http.StatusConflict
+	case errors.Is(err, db.ErrInvalidPollOption):
+		status = http.StatusBadRequest
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	resp, _ := utils.PrepareResponse(errors.Unwrap(err).Error())
+	w.Write(resp)
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"switch-polls-backend/db"
+	"unicode/utf8"
+)
+
+const (
+	maxPollTitleLength       = 256
+	maxPollDescriptionLength = 2048
+	maxOptionContentLength   = 1024
+	maxExtraTypeLength       = 64
+	maxExtraValueLength      = 2048
+	maxScoreScaleSize        = 100
+)
+
+// NormalizePoll fills in the voting settings left empty in the request.
+func NormalizePoll(poll *db.Poll) {
+	if poll.VotingMode == "" {
+		poll.VotingMode = db.VotingModeSingle
+	}
+	switch poll.VotingMode {
+	case db.VotingModeSingle:
+		poll.MinSelections, poll.MaxSelections = 1, 1
+	case db.VotingModeMultiple, db.VotingModeRanked:
+		if poll.MinSelections == 0 {
+			poll.MinSelections = 1
+		}
+		if poll.MaxSelections == 0 {
+			poll.MaxSelections = len(poll.Options)
+		}
+	case db.VotingModeScore:
+		poll.MinSelections, poll.MaxSelections = len(poll.Options), len(poll.Options)
+		if poll.ScoreMin == 0 && poll.ScoreMax == 0 {
+			poll.ScoreMin, poll.ScoreMax = 1, 5
+		}
+	}
+	if poll.Seats == 0 {
+		poll.Seats = 1
+	}
+}
+
+// ValidatePoll checks whether the poll structure fits into the database schema.
+func ValidatePoll(poll db.Poll) error {
+	if len(poll.Title) == 0 || utf8.RuneCountInString(poll.Title) > maxPollTitleLength {
+		return fmt.Errorf("the title must be between 1 and %d characters long", maxPollTitleLength)
+	}
+	if utf8.RuneCountInString(poll.Description) > maxPollDescriptionLength {
+		return fmt.Errorf("the description must be at most %d characters long", maxPollDescriptionLength)
+	}
+	if poll.OpensAt != nil && poll.ClosesAt != nil && !poll.ClosesAt.After(*poll.OpensAt) {
+		return errors.New("the closing time must be after the opening time")
+	}
+	if len(poll.Options) == 0 {
+		return errors.New("the poll must have at least one option")
+	}
+	switch poll.VotingMode {
+	case db.VotingModeSingle:
+	case db.VotingModeMultiple, db.VotingModeRanked:
+		if poll.MinSelections < 1 || poll.MinSelections > poll.MaxSelections || poll.MaxSelections > len(poll.Options) {
+			return errors.New("the selection limits must satisfy 1 <= min_selections <= max_selections <= the number of options")
+		}
+	case db.VotingModeScore:
+		if poll.ScoreMin >= poll.ScoreMax || poll.ScoreMax-poll.ScoreMin > maxScoreScaleSize {
+			return fmt.Errorf("the score scale must satisfy score_min < score_max <= score_min + %d", maxScoreScaleSize)
+		}
+	default:
+		return fmt.Errorf("unknown voting mode '%s'", poll.VotingMode)
+	}
+	if poll.Seats < 1 || poll.Seats > len(poll.Options) {
+		return errors.New("the number of seats must be between 1 and the number of options")
+	}
+	if poll.Seats > 1 && poll.VotingMode != db.VotingModeRanked {
+		return errors.New("only ranked polls can have more than one seat")
+	}
+	for i, opt := range poll.Options {
+		if len(opt.Content) == 0 || utf8.RuneCountInString(opt.Content) > maxOptionContentLength {
+			return fmt.Errorf("option %d: the content must be between 1 and %d characters long", i, maxOptionContentLength)
+		}
+		for j, extra := range opt.Extras {
+			if len(extra.Type) == 0 || utf8.RuneCountInString(extra.Type) > maxExtraTypeLength {
+				return fmt.Errorf("option %d, extra %d: the type must be between 1 and %d characters long", i, j, maxExtraTypeLength)
+			}
+			if utf8.RuneCountInString(extra.Value) > maxExtraValueLength {
+				return fmt.Errorf("option %d, extra %d: the value must be at most %d characters long", i, j, maxExtraValueLength)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"strings"
+	"switch-polls-backend/db"
+	"testing"
+	"time"
+)
+
+func options(n int) []db.PollOption {
+	opts := make([]db.PollOption, n)
+	for i := range opts {
+		opts[i] = db.PollOption{Content: "option"}
+	}
+	return opts
+}
+
+func TestNormalizePoll(t *testing.T) {
+	cases := []struct {
+		name     string
+		poll     db.Poll
+		expected db.Poll
+	}{
+		{"defaults to single", db.Poll{}, db.Poll{VotingMode: db.VotingModeSingle, MinSelections: 1, MaxSelections: 1, Seats: 1}},
+		{"single ignores the limits", db.Poll{VotingMode: db.VotingModeSingle, MinSelections: 2, MaxSelections: 3, Seats: 2},
+			db.Poll{VotingMode: db.VotingModeSingle, MinSelections: 1, MaxSelections: 1, Seats: 2}},
+		{"multiple fills the limits", db.Poll{VotingMode: db.VotingModeMultiple, Options: options(4)},
+			db.Poll{VotingMode: db.VotingModeMultiple, MinSelections: 1, MaxSelections: 4, Seats: 1}},
+		{"ranked keeps the limits", db.Poll{VotingMode: db.VotingModeRanked, MinSelections: 2, MaxSelections: 3, Seats: 2, Options: options(4)},
+			db.Poll{VotingMode: db.VotingModeRanked, MinSelections: 2, MaxSelections: 3, Seats: 2}},
+		{"score selects every option", db.Poll{VotingMode: db.VotingModeScore, MinSelections: 1, MaxSelections: 1, Options: options(3)},
+			db.Poll{VotingMode: db.VotingModeScore, MinSelections: 3, MaxSelections: 3, Seats: 1, ScoreMin: 1, ScoreMax: 5}},
+		{"score keeps the scale", db.Poll{VotingMode: db.VotingModeScore, ScoreMin: -2, ScoreMax: 2, Options: options(2)},
+			db.Poll{VotingMode: db.VotingModeScore, MinSelections: 2, MaxSelections: 2, Seats: 1, ScoreMin: -2, ScoreMax: 2}},
+		{"unknown mode is left for validation", db.Poll{VotingMode: "approval", Options: options(2)},
+			db.Poll{VotingMode: "approval", Seats: 1}},
+	}
+	for _, c := range cases {
+		poll := c.poll
+		NormalizePoll(&poll)
+		if poll.VotingMode != c.expected.VotingMode || poll.MinSelections != c.expected.MinSelections ||
+			poll.MaxSelections != c.expected.MaxSelections || poll.Seats != c.expected.Seats ||
+			poll.ScoreMin != c.expected.ScoreMin || poll.ScoreMax != c.expected.ScoreMax {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, poll)
+		}
+	}
+}
+
+func TestValidatePoll(t *testing.T) {
+	opensAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	closesAt := opensAt.Add(time.Hour)
+	single := func(modify func(p *db.Poll)) db.Poll {
+		poll := db.Poll{Title: "Poll", Options: options(3)}
+		if modify != nil {
+			modify(&poll)
+		}
+		NormalizePoll(&poll)
+		return poll
+	}
+	cases := []struct {
+		name  string
+		poll  db.Poll
+		valid bool
+	}{
+		{"single", single(nil), true},
+		{"empty title", single(func(p *db.Poll) { p.Title = "" }), false},
+		{"longest title", single(func(p *db.Poll) { p.Title = strings.Repeat("ą", maxPollTitleLength) }), true},
+		{"too long title", single(func(p *db.Poll) { p.Title = strings.Repeat("a", maxPollTitleLength+1) }), false},
+		{"too long description", single(func(p *db.Poll) { p.Description = strings.Repeat("a", maxPollDescriptionLength+1) }), false},
+		{"schedule", single(func(p *db.Poll) { p.OpensAt, p.ClosesAt = &opensAt, &closesAt }), true},
+		{"closes when it opens", single(func(p *db.Poll) { p.OpensAt, p.ClosesAt = &opensAt, &opensAt }), false},
+		{"closes before it opens", single(func(p *db.Poll) { p.OpensAt, p.ClosesAt = &closesAt, &opensAt }), false},
+		{"no options", single(func(p *db.Poll) { p.Options = nil }), false},
+		{"unknown mode", single(func(p *db.Poll) { p.VotingMode = "approval" }), false},
+		{"multiple", single(func(p *db.Poll) { p.VotingMode, p.MinSelections, p.MaxSelections = db.VotingModeMultiple, 1, 3 }), true},
+		{"multiple min above max", single(func(p *db.Poll) { p.VotingMode, p.MinSelections, p.MaxSelections = db.VotingModeMultiple, 3, 2 }), false},
+		{"multiple max above options", single(func(p *db.Poll) { p.VotingMode, p.MaxSelections = db.VotingModeMultiple, 4 }), false},
+		{"multiple negative min", single(func(p *db.Poll) { p.VotingMode, p.MinSelections = db.VotingModeMultiple, -1 }), false},
+		{"ranked", single(func(p *db.Poll) { p.VotingMode, p.MinSelections, p.MaxSelections = db.VotingModeRanked, 2, 2 }), true},
+		{"ranked max above options", single(func(p *db.Poll) { p.VotingMode, p.MaxSelections = db.VotingModeRanked, 5 }), false},
+		{"ranked seats", single(func(p *db.Poll) { p.VotingMode, p.Seats = db.VotingModeRanked, 3 }), true},
+		{"ranked seats above options", single(func(p *db.Poll) { p.VotingMode, p.Seats = db.VotingModeRanked, 4 }), false},
+		{"negative seats", single(func(p *db.Poll) { p.VotingMode, p.Seats = db.VotingModeRanked, -1 }), false},
+		{"single seats", single(func(p *db.Poll) { p.Seats = 2 }), false},
+		{"multiple seats", single(func(p *db.Poll) { p.VotingMode, p.Seats = db.VotingModeMultiple, 2 }), false},
+		{"score", single(func(p *db.Poll) { p.VotingMode = db.VotingModeScore }), true},
+		{"score scale", single(func(p *db.Poll) { p.VotingMode, p.ScoreMin, p.ScoreMax = db.VotingModeScore, -5, 5 }), true},
+		{"score empty scale", single(func(p *db.Poll) { p.VotingMode, p.ScoreMin, p.ScoreMax = db.VotingModeScore, 3, 3 }), false},
+		{"score reversed scale", single(func(p *db.Poll) { p.VotingMode, p.ScoreMin, p.ScoreMax = db.VotingModeScore, 5, 1 }), false},
+		{"score largest scale", single(func(p *db.Poll) {
+			p.VotingMode, p.ScoreMin, p.ScoreMax = db.VotingModeScore, 0, maxScoreScaleSize
+		}), true},
+		{"score too large scale", single(func(p *db.Poll) {
+			p.VotingMode, p.ScoreMin, p.ScoreMax = db.VotingModeScore, -1, maxScoreScaleSize
+		}), false},
+		{"empty option", single(func(p *db.Poll) { p.Options[1].Content = "" }), false},
+		{"longest option", single(func(p *db.Poll) { p.Options[1].Content = strings.Repeat("ż", maxOptionContentLength) }), true},
+		{"too long option", single(func(p *db.Poll) { p.Options[1].Content = strings.Repeat("a", maxOptionContentLength+1) }), false},
+		{"extra", single(func(p *db.Poll) {
+			p.Options[0].Extras = []db.OptionExtras{{Type: "image", Value: "https://example.com/a.png"}}
+		}), true},
+		{"extra without type", single(func(p *db.Poll) { p.Options[0].Extras = []db.OptionExtras{{Value: "value"}} }), false},
+		{"too long extra type", single(func(p *db.Poll) {
+			p.Options[0].Extras = []db.OptionExtras{{Type: strings.Repeat("a", maxExtraTypeLength+1)}}
+		}), false},
+		{"too long extra value", single(func(p *db.Poll) {
+			p.Options[0].Extras = []db.OptionExtras{{Type: "text", Value: strings.Repeat("a", maxExtraValueLength+1)}}
+		}), false},
+	}
+	for _, c := range cases {
+		err := ValidatePoll(c.poll)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error %v", c.name, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package captcha
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math/bits"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"switch-polls-backend/config"
+	"sync"
+	"time"
+)
+
+const (
+	ProviderProofOfWork = "pow"
+	ProofOfWorkHeader   = "x-pow-solution"
+	maxPowDifficulty    = 32
+)
+
+var powActionRegex = regexp.MustCompile(`^[a-z_]{1,64}$`)
+
+// ProofOfWorkVerifier is a self-hosted captcha. The client gets an HMAC-signed challenge from ChallengeHandler
+// and has to find a solution such that SHA-256("<challenge>.<solution>") starts with the number of zero bits
+// given as the challenge difficulty. It sends "<challenge>.<solution>" in the x-pow-solution header.
+// Every challenge can be used once.
+type ProofOfWorkVerifier struct {
+	secret []byte
+	conf   config.ProofOfWorkConfiguration
+	now    func() time.Time
+
+	mu sync.Mutex
+	// The nonces of the solved challenges and when they expire
+	used         map[string]int64
+	lastEviction int64
+}
+
+type ChallengeResponse struct {
+	Challenge  string `json:"challenge"`
+	Algorithm  string `json:"algorithm"`
+	Difficulty int    `json:"difficulty"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// NewProofOfWorkVerifier creates the verifier. A random key is generated if conf.Secret is empty, so the
+// challenges issued before a restart stop working.
+func NewProofOfWorkVerifier(conf config.ProofOfWorkConfiguration) *ProofOfWorkVerifier {
+	secret := []byte(conf.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Panicf("cannot generate the proof-of-work key: %v", err)
+		}
+		log.Println("No proof-of-work secret configured, using a random one.")
+	}
+	return &ProofOfWorkVerifier{secret: secret, conf: conf, now: time.Now, used: make(map[string]int64)}
+}
+
+func (v *ProofOfWorkVerifier) TokenHeader() string {
+	return ProofOfWorkHeader
+}
+
+// Difficulty returns the number of leading zero bits required for the challenges of the action.
+func (v *ProofOfWorkVerifier) Difficulty(action string) int {
+	difficulty, ok := v.conf.ActionDifficulty[action]
+	if !ok {
+		difficulty = v.conf.Difficulty
+	}
+	if difficulty > maxPowDifficulty {
+		return maxPowDifficulty
+	}
+	return difficulty
+}
+
+// Issue creates a signed challenge for the action.
+func (v *ProofOfWorkVerifier) Issue(action string) ChallengeResponse {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Panicf("cannot generate a proof-of-work nonce: %v", err)
+	}
+	difficulty := v.Difficulty(action)
+	expiresAt := v.now().Unix() + int64(v.conf.ChallengeTTL)
+	payload := hex.EncodeToString(nonce) + ":" + action + ":" + strconv.Itoa(difficulty) + ":" + strconv.FormatInt(expiresAt, 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return ChallengeResponse{
+		Challenge:  encoded + "." + base64.RawURLEncoding.EncodeToString(v.sign(encoded)),
+		Algorithm:  "sha256",
+		Difficulty: difficulty,
+		ExpiresAt:  expiresAt,
+	}
+}
+
+func (v *ProofOfWorkVerifier) Verify(ctx context.Context, token string, remoteIp string) (*Result, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return rejected("malformed-solution"), nil
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, v.sign(parts[0])) {
+		return rejected("invalid-signature"), nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return rejected("malformed-solution"), nil
+	}
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 4 {
+		return rejected("malformed-solution"), nil
+	}
+	nonce, action := fields[0], fields[1]
+	difficulty, err1 := strconv.Atoi(fields[2])
+	expiresAt, err2 := strconv.ParseInt(fields[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		return rejected("malformed-solution"), nil
+	}
+
+	now := v.now().Unix()
+	if now > expiresAt {
+		return rejected("challenge-expired"), nil
+	}
+	// the configuration may have been changed since the challenge was issued
+	if difficulty < v.Difficulty(action) {
+		return rejected("difficulty-too-low"), nil
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(token))) < difficulty {
+		return rejected("insufficient-work"), nil
+	}
+	if !v.markUsed(nonce, expiresAt, now) {
+		return rejected("challenge-replayed"), nil
+	}
+	return &Result{Success: true, Action: action}, nil
+}
+
+// markUsed records the solved challenge and returns false if it has been solved before. The entries are kept
+// until their challenges expire.
+func (v *ProofOfWorkVerifier) markUsed(nonce string, expiresAt int64, now int64) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now-v.lastEviction >= int64(v.conf.ChallengeTTL) {
+		for n, exp := range v.used {
+			if exp < now {
+				delete(v.used, n)
+			}
+		}
+		v.lastEviction = now
+	}
+	if _, ok := v.used[nonce]; ok {
+		return false
+	}
+	v.used[nonce] = expiresAt
+	return true
+}
+
+func (v *ProofOfWorkVerifier) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// ChallengeHandler issues a challenge for the captcha action given in the 'action' query parameter.
+func (v *ProofOfWorkVerifier) ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Query().Get("action")
+	if !powActionRegex.MatchString(action) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid action"))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	resp, _ := json.Marshal(v.Issue(action))
+	w.Write(resp)
+}
+
+func rejected(code string) *Result {
+	return &Result{Success: false, ErrorCodes: []string{code}}
+}
+
+func leadingZeroBits(hash [32]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
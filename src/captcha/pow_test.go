@@ -0,0 +1,123 @@
+package captcha
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"switch-polls-backend/config"
+	"testing"
+	"time"
+)
+
+var testPowConfig = config.ProofOfWorkConfiguration{
+	Secret:           "secret",
+	ChallengeTTL:     60,
+	Difficulty:       4,
+	ActionDifficulty: map[string]int{"poll_vote": 8},
+}
+
+func solve(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		token := challenge + "." + strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(token))) >= difficulty {
+			return token
+		}
+	}
+}
+
+func expectRejected(t *testing.T, v *ProofOfWorkVerifier, token string, code string) {
+	t.Helper()
+	res, err := v.Verify(context.Background(), token, "")
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if res.Success || len(res.ErrorCodes) != 1 || res.ErrorCodes[0] != code {
+		t.Errorf("expected the token to be rejected with %s, got %+v", code, res)
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	v := NewProofOfWorkVerifier(testPowConfig)
+	challenge := v.Issue("poll_vote")
+	if challenge.Difficulty != 8 {
+		t.Fatalf("expected the per-action difficulty, got %d", challenge.Difficulty)
+	}
+	token := solve(challenge.Challenge, challenge.Difficulty)
+
+	res, err := VerifyRequest(v, newRequest(ProofOfWorkHeader, token), "", "", 0.5)
+	if err != nil {
+		t.Fatalf("expected the solution to pass, got %v", err)
+	}
+	if res.Score != nil || !res.MatchesAction("poll_vote") || res.MatchesAction("poll_get") {
+		t.Errorf("unexpected result %+v", res)
+	}
+	expectRejected(t, v, token, "challenge-replayed")
+}
+
+func TestProofOfWorkRejections(t *testing.T) {
+	v := NewProofOfWorkVerifier(testPowConfig)
+	challenge := v.Issue("poll_get")
+
+	// a solution which does not meet the difficulty
+	for i := 0; ; i++ {
+		token := challenge.Challenge + "." + strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(token))) < challenge.Difficulty {
+			expectRejected(t, v, token, "insufficient-work")
+			break
+		}
+	}
+
+	// a challenge signed with another key
+	other := NewProofOfWorkVerifier(config.ProofOfWorkConfiguration{Secret: "other", ChallengeTTL: 60, Difficulty: 4})
+	expectRejected(t, v, solve(other.Issue("poll_get").Challenge, 4), "invalid-signature")
+
+	// a challenge issued for a lower difficulty than the one configured now
+	weak := NewProofOfWorkVerifier(config.ProofOfWorkConfiguration{Secret: "secret", ChallengeTTL: 60, Difficulty: 1})
+	expectRejected(t, v, solve(weak.Issue("poll_vote").Challenge, 1), "difficulty-too-low")
+
+	// an expired challenge
+	token := solve(challenge.Challenge, challenge.Difficulty)
+	v.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	expectRejected(t, v, token, "challenge-expired")
+
+	expectRejected(t, v, "abc.def", "malformed-solution")
+	expectRejected(t, v, strings.Replace(token, ".", "x.", 1), "invalid-signature")
+}
+
+func TestProofOfWorkEviction(t *testing.T) {
+	v := NewProofOfWorkVerifier(testPowConfig)
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		challenge := v.Issue("poll_get")
+		if res, _ := v.Verify(context.Background(), solve(challenge.Challenge, challenge.Difficulty), ""); !res.Success {
+			t.Fatalf("expected the solution to pass, got %+v", res)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	challenge := v.Issue("poll_get")
+	v.Verify(context.Background(), solve(challenge.Challenge, challenge.Difficulty), "")
+	if len(v.used) != 1 {
+		t.Errorf("expected the expired nonces to be evicted, %d left", len(v.used))
+	}
+}
+
+func TestChallengeHandler(t *testing.T) {
+	v := NewProofOfWorkVerifier(testPowConfig)
+	w := httptest.NewRecorder()
+	v.ChallengeHandler(w, httptest.NewRequest(http.MethodGet, "/challenge?action=poll_vote", nil))
+	var resp ChallengeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Difficulty != 8 || resp.Algorithm != "sha256" {
+		t.Errorf("unexpected challenge response %s (err: %v)", w.Body.String(), err)
+	}
+
+	w = httptest.NewRecorder()
+	v.ChallengeHandler(w, httptest.NewRequest(http.MethodGet, "/challenge?action=DROP%20TABLE", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid action, got %d", w.Code)
+	}
+}
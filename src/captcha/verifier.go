@@ -0,0 +1,224 @@
+// Package captcha verifies the tokens solved by the clients before the protected endpoints are reached.
+// The provider is chosen with WebConfiguration.CaptchaProvider.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"switch-polls-backend/config"
+	"time"
+)
+
+const (
+	ProviderRecaptcha = "recaptcha"
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderTurnstile = "turnstile"
+	ProviderDisabled  = "disabled"
+)
+
+var (
+	ErrMissingToken = errors.New("missing or malformed captcha token")
+	ErrRejected     = errors.New("captcha token rejected by the provider")
+	ErrLowScore     = errors.New("captcha score below the threshold")
+	ErrUnavailable  = errors.New("captcha provider unavailable")
+	ErrWrongAction  = errors.New("captcha token solved for another action")
+)
+
+const maxTokenLength = 4096
+
+var tokenRegex = regexp.MustCompile(`^[A-Za-z0-9_\-.]+$`)
+
+// Result is the outcome of a token verification common to all providers.
+type Result struct {
+	Success  bool
+	Hostname string
+	// The action the token was solved for. Empty if the provider does not report actions.
+	Action string
+	// Nil if the provider does not score the requests
+	Score      *float32
+	ErrorCodes []string
+}
+
+// MatchesAction checks whether the token was solved for the expected action. Tokens of providers
+// which do not report actions match every action.
+func (r Result) MatchesAction(expected string) bool {
+	return r.Action == "" || r.Action == expected
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the verification result.
+func NewContext(ctx context.Context, res *Result) context.Context {
+	return context.WithValue(ctx, contextKey{}, res)
+}
+
+// FromContext returns the verification result of the request, if it went through the captcha middleware.
+func FromContext(ctx context.Context) (*Result, bool) {
+	res, ok := ctx.Value(contextKey{}).(*Result)
+	return res, ok
+}
+
+type CaptchaVerifier interface {
+	// TokenHeader returns the name of the request header carrying the token.
+	TokenHeader() string
+	Verify(ctx context.Context, token string, remoteIp string) (*Result, error)
+}
+
+var DefaultVerifier CaptchaVerifier
+
+func InitVerifier() {
+	var err error
+	DefaultVerifier, err = NewVerifier(&config.Cfg.WebConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialise the captcha verifier: %v", err)
+	}
+	log.Printf("Using the %s captcha provider.", config.Cfg.WebConfig.CaptchaProvider)
+}
+
+func NewVerifier(conf *config.WebConfiguration) (CaptchaVerifier, error) {
+	switch conf.CaptchaProvider {
+	case ProviderRecaptcha, "":
+		return NewRecaptchaVerifier(conf.RecaptchaVerifyEndpoint, conf.RecaptchaSecret), nil
+	case ProviderHCaptcha:
+		return NewHCaptchaVerifier(conf.HCaptchaVerifyEndpoint, conf.HCaptchaSecret, conf.HCaptchaSiteKey), nil
+	case ProviderTurnstile:
+		return NewTurnstileVerifier(conf.TurnstileVerifyEndpoint, conf.TurnstileSecret), nil
+	case ProviderProofOfWork:
+		return NewProofOfWorkVerifier(conf.ProofOfWork), nil
+	case ProviderDisabled:
+		return DisabledVerifier{}, nil
+	}
+	return nil, fmt.Errorf("unknown captcha provider '%s'", conf.CaptchaProvider)
+}
+
+// VerifyRequest verifies the token carried by the request. The returned error is one of ErrMissingToken,
+// ErrRejected, ErrLowScore, ErrWrongAction or ErrUnavailable. Scored tokens have to reach minScore and
+// the tokens reporting an action have to be solved for expectedAction, unless it is empty.
+func VerifyRequest(v CaptchaVerifier, rq *http.Request, remoteIp string, expectedAction string, minScore float32) (*Result, error) {
+	token := rq.Header.Get(v.TokenHeader())
+	if _, disabled := v.(DisabledVerifier); !disabled && (len(token) == 0 || len(token) > maxTokenLength || !tokenRegex.MatchString(token)) {
+		return nil, ErrMissingToken
+	}
+	res, err := v.Verify(rq.Context(), token, remoteIp)
+	if err != nil {
+		log.Printf("Captcha verification error: %v", err)
+		return nil, ErrUnavailable
+	}
+	if !res.Success {
+		return res, fmt.Errorf("%w (errors: %v)", ErrRejected, res.ErrorCodes)
+	}
+	if res.Score != nil && *res.Score < minScore {
+		return res, fmt.Errorf("%w (score: %.3f)", ErrLowScore, *res.Score)
+	}
+	if expectedAction != "" && !res.MatchesAction(expectedAction) {
+		return res, fmt.Errorf("%w (expected: %s, got: %s)", ErrWrongAction, expectedAction, res.Action)
+	}
+	return res, nil
+}
+
+// SiteVerifyVerifier verifies the tokens with a siteverify endpoint. reCAPTCHA, hCaptcha and Turnstile share
+// the request format and the most of the response format.
+type SiteVerifyVerifier struct {
+	Endpoint string
+	Secret   string
+	Header   string
+	// Sent to the endpoint along with the secret and the token
+	Params url.Values
+	// Whether the score of the response is meaningful, i.e. higher scores mean more likely humans
+	Scored bool
+	Client *http.Client
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Hostname   string   `json:"hostname"`
+	Action     string   `json:"action"`
+	Score      *float32 `json:"score"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func NewRecaptchaVerifier(endpoint string, secret string) *SiteVerifyVerifier {
+	return &SiteVerifyVerifier{Endpoint: endpoint, Secret: secret, Header: "g-recaptcha-response", Scored: true, Client: newHttpClient()}
+}
+
+func NewHCaptchaVerifier(endpoint string, secret string, siteKey string) *SiteVerifyVerifier {
+	params := url.Values{}
+	if siteKey != "" {
+		params.Set("sitekey", siteKey)
+	}
+	return &SiteVerifyVerifier{Endpoint: endpoint, Secret: secret, Header: "h-captcha-response", Params: params, Client: newHttpClient()}
+}
+
+func NewTurnstileVerifier(endpoint string, secret string) *SiteVerifyVerifier {
+	return &SiteVerifyVerifier{Endpoint: endpoint, Secret: secret, Header: "cf-turnstile-response", Client: newHttpClient()}
+}
+
+func newHttpClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (v *SiteVerifyVerifier) TokenHeader() string {
+	return v.Header
+}
+
+func (v *SiteVerifyVerifier) Verify(ctx context.Context, token string, remoteIp string) (*Result, error) {
+	data := url.Values{}
+	for k, vals := range v.Params {
+		data[k] = vals
+	}
+	data.Set("secret", v.Secret)
+	data.Set("response", token)
+	if remoteIp != "" {
+		data.Set("remoteip", remoteIp)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.Endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the siteverify endpoint responded with %s", res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	var resp siteVerifyResponse
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Success: resp.Success, Hostname: resp.Hostname, Action: resp.Action, ErrorCodes: resp.ErrorCodes}
+	if v.Scored {
+		score := float32(0)
+		if resp.Score != nil {
+			score = *resp.Score
+		}
+		result.Score = &score
+	}
+	return result, nil
+}
+
+// DisabledVerifier accepts every request. Meant for development only.
+type DisabledVerifier struct{}
+
+func (DisabledVerifier) TokenHeader() string {
+	return "g-recaptcha-response"
+}
+
+func (DisabledVerifier) Verify(ctx context.Context, token string, remoteIp string) (*Result, error) {
+	return &Result{Success: true}, nil
+}
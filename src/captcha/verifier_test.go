@@ -0,0 +1,137 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"switch-polls-backend/config"
+	"testing"
+)
+
+// fakeSiteVerify serves a siteverify endpoint accepting only the "valid-token" token and the "secret" secret.
+func fakeSiteVerify(t *testing.T, validResponse string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected a POST request, got %s", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("cannot parse the form: %v", err)
+		}
+		if r.PostForm.Get("remoteip") != "192.0.2.1" {
+			t.Errorf("unexpected remoteip %q", r.PostForm.Get("remoteip"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("secret") != "secret" || r.PostForm.Get("response") != "valid-token" {
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+			return
+		}
+		w.Write([]byte(validResponse))
+	}))
+}
+
+func newRequest(header string, token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/polls", nil)
+	if token != "" {
+		r.Header.Set(header, token)
+	}
+	return r
+}
+
+func TestRecaptchaVerifier(t *testing.T) {
+	srv := fakeSiteVerify(t, `{"success": true, "hostname": "example.com", "action": "poll_get", "score": 0.7}`)
+	defer srv.Close()
+	v := NewRecaptchaVerifier(srv.URL, "secret")
+
+	res, err := VerifyRequest(v, newRequest("g-recaptcha-response", "valid-token"), "192.0.2.1", "", 0.5)
+	if err != nil {
+		t.Fatalf("expected the token to pass, got %v", err)
+	}
+	if !res.MatchesAction("poll_get") || res.MatchesAction("poll_vote") {
+		t.Errorf("unexpected action matching for action %q", res.Action)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", "valid-token"), "192.0.2.1", "poll_get", 0.5); err != nil {
+		t.Errorf("expected the token to pass for its action, got %v", err)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", "valid-token"), "192.0.2.1", "poll_vote", 0.5); !errors.Is(err, ErrWrongAction) {
+		t.Errorf("expected ErrWrongAction, got %v", err)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", "valid-token"), "192.0.2.1", "", 0.9); !errors.Is(err, ErrLowScore) {
+		t.Errorf("expected ErrLowScore, got %v", err)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", "other-token"), "192.0.2.1", "", 0.5); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected ErrRejected, got %v", err)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", ""), "192.0.2.1", "", 0.5); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("expected ErrMissingToken, got %v", err)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", "bad token!"), "192.0.2.1", "", 0.5); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("expected ErrMissingToken for a malformed token, got %v", err)
+	}
+}
+
+func TestHCaptchaVerifier(t *testing.T) {
+	// hCaptcha Enterprise reports risk scores, where higher means more suspicious; they must not be compared to the threshold
+	srv := fakeSiteVerify(t, `{"success": true, "hostname": "example.com", "score": 0.9}`)
+	defer srv.Close()
+	v := NewHCaptchaVerifier(srv.URL, "secret", "")
+
+	res, err := VerifyRequest(v, newRequest("h-captcha-response", "valid-token"), "192.0.2.1", "", 0.5)
+	if err != nil {
+		t.Fatalf("expected the token to pass, got %v", err)
+	}
+	if res.Score != nil || !res.MatchesAction("poll_vote") {
+		t.Errorf("hCaptcha results should have neither a score nor an action: %+v", res)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", "valid-token"), "192.0.2.1", "", 0.5); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("expected the token in the reCAPTCHA header to be ignored, got %v", err)
+	}
+}
+
+func TestTurnstileVerifier(t *testing.T) {
+	srv := fakeSiteVerify(t, `{"success": true, "hostname": "example.com", "action": "poll_vote"}`)
+	defer srv.Close()
+	v := NewTurnstileVerifier(srv.URL, "secret")
+
+	res, err := VerifyRequest(v, newRequest("cf-turnstile-response", "valid-token"), "192.0.2.1", "", 0.5)
+	if err != nil {
+		t.Fatalf("expected the token to pass, got %v", err)
+	}
+	if res.Score != nil || !res.MatchesAction("poll_vote") || res.MatchesAction("poll_get") {
+		t.Errorf("unexpected Turnstile result %+v", res)
+	}
+}
+
+func TestUnavailableProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	v := NewRecaptchaVerifier(srv.URL, "secret")
+	if _, err := VerifyRequest(v, newRequest("g-recaptcha-response", "valid-token"), "192.0.2.1", "", 0.5); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestDisabledVerifier(t *testing.T) {
+	v, err := NewVerifier(&config.WebConfiguration{CaptchaProvider: ProviderDisabled})
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+	if _, err = VerifyRequest(v, newRequest("g-recaptcha-response", ""), "", "", 0.5); err != nil {
+		t.Errorf("expected the disabled verifier to accept requests without a token, got %v", err)
+	}
+	if _, err = NewVerifier(&config.WebConfiguration{CaptchaProvider: "abacus"}); err == nil {
+		t.Errorf("expected an error for an unknown provider")
+	}
+}
+
+func TestResultContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Errorf("expected no result in an empty context")
+	}
+	ctx := NewContext(context.Background(), &Result{Success: true, Action: "poll_get"})
+	if res, ok := FromContext(ctx); !ok || res.Action != "poll_get" {
+		t.Errorf("unexpected result %+v in the context", res)
+	}
+}
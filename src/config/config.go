@@ -2,11 +2,14 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 )
 
 const MigrationsPathRelative = "migrations"
@@ -14,11 +17,40 @@ const MigrationsPathRelative = "migrations"
 var Cfg *Configuration
 
 type CORSConfiguration struct {
+	// * or a list of origins separated by commas or spaces. A request from a listed origin gets the origin echoed
+	// in its Access-Control-Allow-Origin header.
 	AccessControlAllowOrigin  string
 	AccessControlAllowHeaders string
 }
 
+// Origins splits AccessControlAllowOrigin into the allowed origins.
+func (c *CORSConfiguration) Origins() []string {
+	return strings.FieldsFunc(c.AccessControlAllowOrigin, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// AllowOrigin returns the Access-Control-Allow-Origin value for a request with the given Origin header, empty if
+// the origin is not allowed. varies reports whether the value depends on the origin, i.e. the response needs
+// the Vary: Origin header.
+func (c *CORSConfiguration) AllowOrigin(origin string) (value string, varies bool) {
+	origins := c.Origins()
+	if len(origins) == 1 && (origins[0] == "*" || origins[0] == "null") {
+		return origins[0], false
+	}
+	for _, allowed := range origins {
+		if origin != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin, true
+		}
+	}
+	return "", true
+}
+
 type EmailConfiguration struct {
+	// The backend delivering the emails: smtp, file, log or memory
+	Mailer string
+	// The maildir the file mailer writes to
+	MailerDirectory    string
 	OrganizationDomain string
 	// The contents of 'FROM' email header
 	SenderEmail           string
@@ -26,45 +58,210 @@ type EmailConfiguration struct {
 	SmtpHost              string
 	SmtpPort              int
 	SenderEmailPasswd     string
-	EmailSubject          string
-	EmailTemplatePath     string
+	// Outgoing emails are DKIM signed if DkimPrivateKeyPath is set. The key is a PEM encoded RSA or Ed25519 private key
+	// and its public part is published in the DNS TXT record <DkimSelector>._domainkey.<DkimDomain>
+	DkimDomain         string
+	DkimSelector       string
+	DkimPrivateKeyPath string
+	EmailSubject       string
+	EmailTemplatePath  string
 	// Internal-use only - contents of the file specified in EmailTemplatePath is loaded there upon startup
 	EmailTemplate string `json:"-"`
+	// Plain-text alternative of the template in EmailTemplatePath
+	EmailTextTemplatePath string
+	// Internal-use only - contents of the file specified in EmailTextTemplatePath is loaded there upon startup
+	EmailTextTemplate           string `json:"-"`
+	WithdrawalEmailSubject      string
+	WithdrawalEmailTemplatePath string
+	// Internal-use only - contents of the file specified in WithdrawalEmailTemplatePath is loaded there upon startup
+	WithdrawalEmailTemplate string `json:"-"`
+	// Plain-text alternative of the template in WithdrawalEmailTemplatePath
+	WithdrawalEmailTextTemplatePath string
+	// Internal-use only - contents of the file specified in WithdrawalEmailTextTemplatePath is loaded there upon startup
+	WithdrawalEmailTextTemplate string `json:"-"`
+	// Limits of the confirmation emails sent to one recipient and requested from one address
+	Throttling EmailThrottlingConfiguration
+	// The key signing the opt-out links. The links are left out of the emails when it is empty.
+	OptOutSecret string
+}
+
+// EmailTemplateValues are the values available to the email templates. The templates are checked against them
+// when the configuration is validated.
+type EmailTemplateValues struct {
+	Receiver    string
+	ServiceName string
+	VoteOption  string
+	PollTitle   string
+	PollId      string
+	Link        string
+	// Whether the vote replaces an earlier confirmed one
+	IsChange bool
+	// The link stopping all further emails to the receiver, empty if opting out is not configured
+	OptOutLink string
+}
+
+// EmailThrottlingConfiguration limits the number of emails per hour and per day. Non-positive values disable a limit.
+type EmailThrottlingConfiguration struct {
+	RecipientHourly int
+	RecipientDaily  int
+	SourceIpHourly  int
+	SourceIpDaily   int
 }
 
 type WebConfiguration struct {
-	CORS                              CORSConfiguration
-	EndpointsLimits                   EndpointsLimits
-	ListeningAddress                  string
-	Domain                            string
-	Port                              uint
-	Protocol                          string
-	ApiPrefix                         string
-	RecaptchaMinScore                 float32
-	RecaptchaVerifyEndpoint           string
-	RecaptchaSecret                   string
+	CORS             CORSConfiguration
+	EndpointsLimits  EndpointsLimits
+	ListeningAddress string
+	Domain           string
+	Port             uint
+	Protocol         string
+	ApiPrefix        string
+	// The captcha protecting the polls endpoints: recaptcha (v3), hcaptcha, turnstile, pow (the built-in proof of work)
+	// or disabled (development only)
+	CaptchaProvider         string
+	RecaptchaMinScore       float32
+	RecaptchaVerifyEndpoint string
+	RecaptchaSecret         string
+	HCaptchaVerifyEndpoint  string
+	HCaptchaSecret          string
+	// Optional, makes hCaptcha check that the token was issued for this site
+	HCaptchaSiteKey         string
+	TurnstileVerifyEndpoint string
+	TurnstileSecret         string
+	ProofOfWork             ProofOfWorkConfiguration
+	// The captcha requirements of the protected routes, by route name. Requests to routes missing here are rejected.
+	CaptchaRoutes                     map[string]CaptchaRouteConfiguration
 	TokenVerificationRedirectLocation string
+	// For how long (in seconds) the confirmation tokens are valid. Non-positive values disable the expiration.
+	ConfirmationTokenTTL int
+	// How long (in seconds) a user has to wait before the confirmation email of their pending vote can be resent
+	ConfirmationResendCooldown int
+	// CIDRs (or single addresses) of the reverse proxies whose Forwarded, X-Forwarded-For and X-Real-IP headers
+	// are trusted. The headers are ignored for requests coming from other addresses.
+	TrustedProxies []string
+	// Bearer tokens accepted by the admin endpoints. The admin API is unavailable when the list is empty.
+	AdminApiKeys []string
+	// Where the Prometheus metrics are exposed, relative to the listening address. Empty disables the endpoint.
+	MetricsPath string
+	// Whether scraping the metrics requires one of AdminApiKeys. The metrics include per-poll vote counts.
+	MetricsRequireAdminKey bool
+	// How long (in seconds) each readiness check may take before it is reported as failed
+	HealthCheckTimeout int
+	// Whether the readiness check connects to the SMTP server. Only applies to the smtp mailer.
+	HealthCheckSmtp bool
+	Timeouts        TimeoutsConfiguration
+}
+
+// TimeoutsConfiguration holds the timeouts of the http server, in seconds. Non-positive values disable them.
+type TimeoutsConfiguration struct {
+	// Reading the whole request, including the body
+	Read int
+	// Reading the request headers
+	ReadHeader int
+	// From the end of reading the request headers to the end of writing the response
+	Write int
+	// Keeping an idle keep-alive connection open
+	Idle int
+	// Waiting for the in-flight requests and the background workers to finish after SIGTERM or SIGINT
+	Shutdown int
+}
+
+type CaptchaRouteConfiguration struct {
+	// The action the token has to be solved for. Not checked if empty or if the provider does not report actions.
+	Action string
+	// The minimum score of the providers scoring the requests. RecaptchaMinScore is used if it is not positive.
+	MinScore float32
+}
+
+type ProofOfWorkConfiguration struct {
+	// The key signing the challenges. A random one is generated on startup if it is empty.
+	Secret string
+	// For how long (in seconds) a challenge can be solved
+	ChallengeTTL int
+	// The number of leading zero bits of the solution hash
+	Difficulty int
+	// Overrides Difficulty for the challenges of the given captcha actions, e.g. {"poll_vote": 20}
+	ActionDifficulty map[string]int
 }
 
 type EndpointsLimits struct {
 	Polls PollLimits
+	Admin AdminLimits
+}
+
+type AdminLimits struct {
+	PollsEndpoint  Limits
+	OutboxEndpoint Limits
 }
 
 type PollLimits struct {
+	ListEndpoint        Limits
 	PollEndpoint        Limits
 	ResultsEndpoint     Limits
 	VotesEndpoint       Limits
 	ConfirmVoteEndpoint Limits
+	ResendEndpoint      Limits
+	OptOutEndpoint      Limits
+	// Both the vote withdrawal request and its confirmation
+	WithdrawEndpoint          Limits
+	ConfirmWithdrawalEndpoint Limits
+	// The proof-of-work challenges, served when CaptchaProvider is pow
+	ChallengeEndpoint Limits
 }
 
 type Limits struct {
 	MaxBodySize int
+	// Limits the requests from one client IP address
+	IpRateLimit RateLimit
+	// Limits the requests concerning one username, on the endpoints taking one
+	UserRateLimit RateLimit
+}
+
+// RateLimit is a token bucket refilled with PerMinute tokens a minute and holding up to Burst tokens.
+// It is disabled if either value is not positive.
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+}
+
+type OutboxConfiguration struct {
+	// The number of emails sent concurrently
+	Workers int
+	// How often (in seconds) the outbox is checked for emails due for delivery
+	PollInterval int
+	// The maximum number of emails claimed at once
+	BatchSize int
+	// After how many failed attempts the email is moved to the dead letters
+	MaxAttempts int
+	// The delay (in seconds) before the first retry, doubled after each failed attempt up to MaxBackoff
+	InitialBackoff int
+	MaxBackoff     int
+	// After how many seconds an email stuck in sending is claimed again, which counts as a failed attempt
+	ClaimTimeout int
+}
+
+type WorkersConfiguration struct {
+	Outbox OutboxConfiguration
+	// How often (in seconds) the polls past their closing time are made read-only
+	PollSchedulerInterval int
+	// How often (in seconds) the expired tokens, unconfirmed votes, inactive users and old outbox emails are removed
+	CleanupInterval int
+	// After how many seconds the unconfirmed votes are removed. Non-positive values disable the removal.
+	UnconfirmedVoteRetention int
+	// After how many seconds the users without any vote are removed. Non-positive values disable the removal.
+	InactiveUserRetention int
+	// After how many seconds the sent and dead emails are removed from the outbox. Non-positive values disable the
+	// removal.
+	OutboxRetention int
+	// How often (in seconds) the idle rate limiter buckets are dropped
+	RateLimitEvictionInterval int
 }
 
 type Configuration struct {
 	DebugMode      bool
 	EmailConfig    EmailConfiguration
 	WebConfig      WebConfiguration
+	WorkersConfig  WorkersConfiguration
 	DbString       string
 	DatabaseConfig *mysql.Config `json:"-"`
 }
@@ -72,62 +269,195 @@ type Configuration struct {
 var defaultConfig = Configuration{
 	DebugMode: false,
 	EmailConfig: EmailConfiguration{
-		OrganizationDomain:    "zsi.kielce.pl",
-		SenderEmail:           "switch@zsi.kielce.pl",
-		SenderMailboxUsername: "switch",
-		SmtpHost:              "smtp.zsi.kielce.pl",
-		SmtpPort:              587,
-		SenderEmailPasswd:     "",
-		EmailSubject:          "[SWITCH POLLS] Potwierdź swój głos",
-		EmailTemplatePath:     "./EmailTemplate.html",
+		Mailer:                          "smtp",
+		MailerDirectory:                 "./mail",
+		OrganizationDomain:              "zsi.kielce.pl",
+		SenderEmail:                     "switch@zsi.kielce.pl",
+		SenderMailboxUsername:           "switch",
+		SmtpHost:                        "smtp.zsi.kielce.pl",
+		SmtpPort:                        587,
+		SenderEmailPasswd:               "",
+		EmailSubject:                    "[SWITCH POLLS] Potwierdź swój głos",
+		EmailTemplatePath:               "./EmailTemplate.html",
+		EmailTextTemplatePath:           "./EmailTemplate.txt",
+		WithdrawalEmailSubject:          "[SWITCH POLLS] Potwierdź wycofanie głosu",
+		WithdrawalEmailTemplatePath:     "./WithdrawalEmailTemplate.html",
+		WithdrawalEmailTextTemplatePath: "./WithdrawalEmailTemplate.txt",
+		Throttling: EmailThrottlingConfiguration{
+			RecipientHourly: 5,
+			RecipientDaily:  20,
+			SourceIpHourly:  30,
+			SourceIpDaily:   200,
+		},
 	},
 	WebConfig: WebConfiguration{
 		CORS: CORSConfiguration{
 			AccessControlAllowOrigin:  "*",
-			AccessControlAllowHeaders: "g-recaptcha-response",
+			AccessControlAllowHeaders: "g-recaptcha-response, h-captcha-response, cf-turnstile-response, x-pow-solution",
 		},
 		EndpointsLimits: EndpointsLimits{
 			Polls: PollLimits{
+				ListEndpoint: Limits{
+					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 120, Burst: 60},
+				},
 				PollEndpoint: Limits{
 					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 120, Burst: 60},
 				},
 				ResultsEndpoint: Limits{
 					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 120, Burst: 60},
 				},
 				VotesEndpoint: Limits{
-					MaxBodySize: 1024,
+					MaxBodySize:   1024,
+					IpRateLimit:   RateLimit{PerMinute: 60, Burst: 30},
+					UserRateLimit: RateLimit{PerMinute: 6, Burst: 3},
 				},
 				ConfirmVoteEndpoint: Limits{
 					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 30, Burst: 10},
+				},
+				ResendEndpoint: Limits{
+					MaxBodySize:   512,
+					IpRateLimit:   RateLimit{PerMinute: 30, Burst: 10},
+					UserRateLimit: RateLimit{PerMinute: 2, Burst: 2},
+				},
+				OptOutEndpoint: Limits{
+					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 10, Burst: 5},
+				},
+				WithdrawEndpoint: Limits{
+					MaxBodySize:   512,
+					IpRateLimit:   RateLimit{PerMinute: 30, Burst: 10},
+					UserRateLimit: RateLimit{PerMinute: 4, Burst: 2},
+				},
+				ConfirmWithdrawalEndpoint: Limits{
+					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 30, Burst: 10},
+				},
+				ChallengeEndpoint: Limits{
+					MaxBodySize: 0,
+					IpRateLimit: RateLimit{PerMinute: 120, Burst: 60},
+				},
+			},
+			Admin: AdminLimits{
+				PollsEndpoint: Limits{
+					MaxBodySize: 65536,
+				},
+				OutboxEndpoint: Limits{
+					MaxBodySize: 0,
 				},
 			},
 		},
+		Domain:                  "localhost",
+		Port:                    8080,
+		Protocol:                "http",
 		ApiPrefix:               "/api",
 		RecaptchaMinScore:       0.51,
+		CaptchaProvider:         "recaptcha",
 		RecaptchaVerifyEndpoint: "https://www.google.com/recaptcha/api/siteverify",
+		HCaptchaVerifyEndpoint:  "https://api.hcaptcha.com/siteverify",
+		TurnstileVerifyEndpoint: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		CaptchaRoutes: map[string]CaptchaRouteConfiguration{
+			"polls_list":         {Action: "polls_list"},
+			"poll_get":           {Action: "poll_get"},
+			"poll_results_get":   {Action: "poll_results_get"},
+			"poll_vote":          {Action: "poll_vote"},
+			"poll_vote_resend":   {Action: "poll_vote_resend"},
+			"poll_vote_change":   {Action: "poll_vote_change"},
+			"poll_vote_withdraw": {Action: "poll_vote_withdraw"},
+		},
+		ProofOfWork: ProofOfWorkConfiguration{
+			ChallengeTTL: 300,
+			Difficulty:   16,
+			ActionDifficulty: map[string]int{
+				"poll_vote":          20,
+				"poll_vote_change":   20,
+				"poll_vote_resend":   20,
+				"poll_vote_withdraw": 20,
+			},
+		},
+		ConfirmationTokenTTL:       24 * 3600,
+		ConfirmationResendCooldown: 300,
+		TrustedProxies:             []string{},
+		AdminApiKeys:               []string{},
+		MetricsPath:                "/metrics",
+		MetricsRequireAdminKey:     true,
+		HealthCheckTimeout:         2,
+		HealthCheckSmtp:            false,
+		Timeouts: TimeoutsConfiguration{
+			Read:       10,
+			ReadHeader: 5,
+			Write:      30,
+			Idle:       60,
+			Shutdown:   30,
+		},
+	},
+	WorkersConfig: WorkersConfiguration{
+		Outbox: OutboxConfiguration{
+			Workers:        4,
+			PollInterval:   5,
+			BatchSize:      20,
+			MaxAttempts:    8,
+			InitialBackoff: 30,
+			MaxBackoff:     3600,
+			ClaimTimeout:   600,
+		},
+		PollSchedulerInterval:     30,
+		CleanupInterval:           3600,
+		UnconfirmedVoteRetention:  7 * 24 * 3600,
+		InactiveUserRetention:     7 * 24 * 3600,
+		OutboxRetention:           7 * 24 * 3600,
+		RateLimitEvictionInterval: 60,
 	},
 	DbString: "username:passwd@tcp(localhost:3306)/mydatabase?parseTime=true",
 }
 
 // flags
-var configPath string
+var (
+	configPath  string
+	checkConfig bool
+)
 
 func InitConfig() {
 	log.Println("Initialising config...")
 	var err error
-	flag.StringVar(&configPath, "cfg", "./config.json", "The path to the config file.")
+	flag.StringVar(&configPath, "cfg", "./config.json", "The path to the config file. Its fields can be overridden by "+
+		EnvPrefix+"_<FIELD> environment variables and "+EnvPrefix+"_<FIELD>"+EnvFileSuffix+" secret files.")
+	flag.BoolVar(&checkConfig, "check-config", false, "Validate the configuration, report the errors and exit.")
 	flag.Parse()
 
+	created := false
 	f, err := os.Open(configPath)
 	if os.IsNotExist(err) {
 		log.Printf("No config file found in %s (the path is customisable via -cfg <path> argument)", configPath)
+		if checkConfig {
+			fmt.Fprintf(os.Stderr, "The config file %s does not exist.\n", configPath)
+			os.Exit(1)
+		}
 		createConfig(configPath)
+		created = true
 	} else {
 		log.Printf("Found a file (possibly config) in '%s'", configPath)
 		f.Close()
 	}
 
 	Cfg, err = loadConfiguration(configPath)
+	if checkConfig {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: the configuration is valid.\n", configPath)
+		os.Exit(0)
+	}
+	if err != nil && created {
+		// the deployment specific fields, e.g. the captcha secret, have no usable defaults
+		log.Fatalf("The config file %s has been created with the default values. Fill in the fields below in it, or "+
+			"set their %s_<FIELD> environment variables, and start again. %s\n", configPath, EnvPrefix, err)
+		return
+	}
 	if err != nil {
 		log.Fatalf("Failed to load configuration! Error: %s\n", err)
 		return
@@ -170,8 +500,28 @@ func createConfig(path string) {
 	log.Println("Config created!")
 }
 
+// clone copies the configuration together with its maps and slices, which decoding JSON into the copy would
+// otherwise modify in place.
+func (c Configuration) clone() Configuration {
+	routes := c.WebConfig.CaptchaRoutes
+	c.WebConfig.CaptchaRoutes = make(map[string]CaptchaRouteConfiguration, len(routes))
+	for k, v := range routes {
+		c.WebConfig.CaptchaRoutes[k] = v
+	}
+	difficulty := c.WebConfig.ProofOfWork.ActionDifficulty
+	c.WebConfig.ProofOfWork.ActionDifficulty = make(map[string]int, len(difficulty))
+	for k, v := range difficulty {
+		c.WebConfig.ProofOfWork.ActionDifficulty[k] = v
+	}
+	c.WebConfig.TrustedProxies = append([]string{}, c.WebConfig.TrustedProxies...)
+	c.WebConfig.AdminApiKeys = append([]string{}, c.WebConfig.AdminApiKeys...)
+	return c
+}
+
 func loadConfiguration(path string) (*Configuration, error) {
-	conf := &Configuration{}
+	// fields missing from the file keep their default values
+	defaults := defaultConfig.clone()
+	conf := &defaults
 	cfgFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -182,19 +532,42 @@ func loadConfiguration(path string) (*Configuration, error) {
 	if err != nil {
 		return nil, err
 	}
+	// the invalid overrides are reported together with the invalid fields
+	errs := applyEnvOverrides(conf, os.LookupEnv)
+	var verrs ValidationErrors
+	if errors.As(conf.Validate(), &verrs) {
+		errs = append(errs, verrs...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	conf.DatabaseConfig, err = mysql.ParseDSN(conf.DbString)
 	if err != nil {
 		return nil, err
 	}
-	loadEmailTemplate(conf, conf.EmailConfig.EmailTemplatePath)
+	templates := []struct {
+		path string
+		dst  *string
+	}{
+		{conf.EmailConfig.EmailTemplatePath, &conf.EmailConfig.EmailTemplate},
+		{conf.EmailConfig.EmailTextTemplatePath, &conf.EmailConfig.EmailTextTemplate},
+		{conf.EmailConfig.WithdrawalEmailTemplatePath, &conf.EmailConfig.WithdrawalEmailTemplate},
+		{conf.EmailConfig.WithdrawalEmailTextTemplatePath, &conf.EmailConfig.WithdrawalEmailTextTemplate},
+	}
+	for _, t := range templates {
+		*t.dst, err = loadEmailTemplate(t.path)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return conf, nil
 }
 
-func loadEmailTemplate(cfg *Configuration, path string) {
+func loadEmailTemplate(path string) (string, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Panicf("template file load error: %v\n", err)
+		return "", fmt.Errorf("template file load error: %v", err)
 	}
-	cfg.EmailConfig.EmailTemplate = string(data)
+	return string(data), nil
 }
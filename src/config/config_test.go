@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigurationKeepsDefaults(t *testing.T) {
+	routes := defaultConfig.clone().WebConfig.CaptchaRoutes
+	difficulty := defaultConfig.clone().WebConfig.ProofOfWork.ActionDifficulty
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"WebConfig": {
+		"CaptchaRoutes": {"custom_route": {"Action": "custom"}, "poll_vote": {"Action": "other"}},
+		"ProofOfWork": {"ActionDifficulty": {"poll_vote": 25}},
+		"AdminApiKeys": ["key"]
+	}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the templates are missing, only the side effects of decoding matter
+	loadConfiguration(path)
+
+	if !reflect.DeepEqual(defaultConfig.WebConfig.CaptchaRoutes, routes) {
+		t.Errorf("the default captcha routes were modified: %v", defaultConfig.WebConfig.CaptchaRoutes)
+	}
+	if !reflect.DeepEqual(defaultConfig.WebConfig.ProofOfWork.ActionDifficulty, difficulty) {
+		t.Errorf("the default action difficulty was modified: %v", defaultConfig.WebConfig.ProofOfWork.ActionDifficulty)
+	}
+	if len(defaultConfig.WebConfig.AdminApiKeys) != 0 {
+		t.Errorf("the default admin keys were modified: %v", defaultConfig.WebConfig.AdminApiKeys)
+	}
+}
+
+func TestLoadConfigurationReportsEnvOverrideErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"DbString": "not a dsn"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SPOLLS_WEB_CONFIG_PORT", "port")
+
+	_, err := loadConfiguration(path)
+	got := fieldsOf(err)
+	if len(got) < 2 || got[0] != "SPOLLS_WEB_CONFIG_PORT" || got[1] != "DbString" {
+		t.Fatalf("expected the override and the validation errors in one report, got %v", got)
+	}
+	if !strings.Contains(err.Error(), "SPOLLS_WEB_CONFIG_PORT: strconv.ParseUint") {
+		t.Errorf("unexpected report:\n%v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Every field of Configuration can be overridden from the environment. The sources are resolved in the following
+// order, each one overriding the previous ones:
+//
+//  1. the defaults (defaultConfig),
+//  2. the JSON config file (-cfg),
+//  3. the SPOLLS_<FIELD> environment variable,
+//  4. the contents of the file the SPOLLS_<FIELD>_FILE environment variable points at, e.g. a Docker or Kubernetes
+//     secret. A single trailing newline is dropped.
+//
+// <FIELD> is the path of the field with its names converted to upper snake case, e.g. EmailConfig.SenderEmailPasswd
+// becomes SPOLLS_EMAIL_CONFIG_SENDER_EMAIL_PASSWD and WebConfig.CORS.AccessControlAllowOrigin becomes
+// SPOLLS_WEB_CONFIG_CORS_ACCESS_CONTROL_ALLOW_ORIGIN. Lists of strings are comma-separated; maps, and lists of other
+// types, are given in JSON. A list replaces the previous one, while a map is merged into the previous one: its
+// entries are added or replaced and the other entries are kept, e.g. SPOLLS_WEB_CONFIG_CAPTCHA_ROUTES only needs the
+// routes whose settings change. Entries can only be removed in the config file. Fields skipped by the config file
+// (json:"-") cannot be overridden either.
+const (
+	EnvPrefix     = "SPOLLS"
+	EnvFileSuffix = "_FILE"
+)
+
+// envField is a configuration field that can be overridden from the environment.
+type envField struct {
+	Name  string
+	Value reflect.Value
+}
+
+// applyEnvOverrides overrides the fields of conf with the values found by lookup. All the invalid values are reported
+// in the returned ValidationErrors, by the variables they were read from.
+func applyEnvOverrides(conf *Configuration, lookup func(key string) (string, bool)) ValidationErrors {
+	var errs ValidationErrors
+	for _, f := range envFields(conf) {
+		raw, source, ok, err := lookupEnvValue(f.Name, lookup)
+		if err != nil {
+			errs = append(errs, FieldError{Field: f.Name + EnvFileSuffix, Message: err.Error()})
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := setFromString(f.Value, raw); err != nil {
+			errs = append(errs, FieldError{Field: source, Message: err.Error()})
+			continue
+		}
+		log.Printf("Config field overridden by %s", source)
+	}
+	return errs
+}
+
+// lookupEnvValue returns the value of the name variable, or the contents of the file pointed at by name_FILE,
+// along with the source it was taken from.
+func lookupEnvValue(name string, lookup func(key string) (string, bool)) (string, string, bool, error) {
+	if path, ok := lookup(name + EnvFileSuffix); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", false, err
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		return value, name + EnvFileSuffix, true, nil
+	}
+	value, ok := lookup(name)
+	return value, name, ok, nil
+}
+
+// envFields lists the overridable fields of conf, sorted by their variable names.
+func envFields(conf *Configuration) []envField {
+	var fields []envField
+	collectEnvFields(reflect.ValueOf(conf).Elem(), EnvPrefix, &fields)
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	return fields
+}
+
+func collectEnvFields(v reflect.Value, prefix string, fields *[]envField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || sf.Tag.Get("json") == "-" {
+			continue
+		}
+		name := prefix + "_" + envName(sf.Name)
+		if sf.Type.Kind() == reflect.Struct {
+			collectEnvFields(v.Field(i), name, fields)
+			continue
+		}
+		*fields = append(*fields, envField{Name: name, Value: v.Field(i)})
+	}
+}
+
+// envName converts a CamelCase field name to UPPER_SNAKE_CASE. Acronyms are kept together, e.g. HCaptchaSiteKey
+// becomes H_CAPTCHA_SITE_KEY and CORS stays CORS.
+func envName(field string) string {
+	runes := []rune(field)
+	var sb strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+// setFromString parses raw according to the type of the field and stores the result in it.
+func setFromString(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(raw), 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			items := []string{}
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items).Convert(field.Type()))
+			return nil
+		}
+		return setFromJson(field, raw)
+	default:
+		return setFromJson(field, raw)
+	}
+	return nil
+}
+
+// setFromJson stores the decoded JSON in the field. Maps are merged with their previous contents into a new map,
+// so that the map the field shared with the defaults is not modified; any other value is replaced.
+func setFromJson(field reflect.Value, raw string) error {
+	v := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(raw), v.Interface()); err != nil {
+		return err
+	}
+	if field.Kind() == reflect.Map && !field.IsNil() && !v.Elem().IsNil() {
+		merged := reflect.MakeMapWithSize(field.Type(), field.Len()+v.Elem().Len())
+		for _, m := range []reflect.Value{field, v.Elem()} {
+			iter := m.MapRange()
+			for iter.Next() {
+				merged.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		field.Set(merged)
+		return nil
+	}
+	field.Set(v.Elem())
+	return nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"DbString":                 "DB_STRING",
+		"CORS":                     "CORS",
+		"HCaptchaSiteKey":          "H_CAPTCHA_SITE_KEY",
+		"AccessControlAllowOrigin": "ACCESS_CONTROL_ALLOW_ORIGIN",
+		"IpRateLimit":              "IP_RATE_LIMIT",
+		"ConfirmationTokenTTL":     "CONFIRMATION_TOKEN_TTL",
+		"Read":                     "READ",
+	}
+	for field, expected := range cases {
+		if got := envName(field); got != expected {
+			t.Errorf("envName(%s) = %s, expected %s", field, got, expected)
+		}
+	}
+}
+
+func TestEnvFieldNamesAreUnique(t *testing.T) {
+	conf := defaultConfig
+	seen := map[string]bool{}
+	for _, f := range envFields(&conf) {
+		for _, name := range []string{f.Name, f.Name + EnvFileSuffix} {
+			if seen[name] {
+				t.Errorf("duplicate environment variable %s", name)
+			}
+			seen[name] = true
+		}
+	}
+	for _, name := range []string{"SPOLLS_DB_STRING", "SPOLLS_EMAIL_CONFIG_SENDER_EMAIL_PASSWD", "SPOLLS_WEB_CONFIG_RECAPTCHA_SECRET"} {
+		if !seen[name] {
+			t.Errorf("missing environment variable %s", name)
+		}
+	}
+	if seen["SPOLLS_DATABASE_CONFIG"] || seen["SPOLLS_EMAIL_CONFIG_EMAIL_TEMPLATE"] {
+		t.Errorf("internal fields must not be overridable")
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	conf := defaultConfig
+	err := applyEnvOverrides(&conf, lookupFrom(map[string]string{
+		"SPOLLS_DEBUG_MODE":                                 "true",
+		"SPOLLS_DB_STRING":                                  "user:pass@tcp(db:3306)/spolls",
+		"SPOLLS_EMAIL_CONFIG_SMTP_PORT":                     "2525",
+		"SPOLLS_WEB_CONFIG_PORT":                            "8081",
+		"SPOLLS_WEB_CONFIG_RECAPTCHA_MIN_SCORE":             "0.7",
+		"SPOLLS_WEB_CONFIG_ADMIN_API_KEYS":                  "first, second,,",
+		"SPOLLS_WEB_CONFIG_TRUSTED_PROXIES":                 `["10.0.0.0/8"]`,
+		"SPOLLS_WEB_CONFIG_PROOF_OF_WORK_ACTION_DIFFICULTY": `{"poll_vote": 22, "custom": 18}`,
+		"SPOLLS_WEB_CONFIG_ENDPOINTS_LIMITS_POLLS_VOTES_ENDPOINT_USER_RATE_LIMIT_BURST": "7",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conf.DebugMode || conf.DbString != "user:pass@tcp(db:3306)/spolls" || conf.EmailConfig.SmtpPort != 2525 ||
+		conf.WebConfig.Port != 8081 || conf.WebConfig.RecaptchaMinScore != 0.7 {
+		t.Errorf("scalar fields not overridden: %+v", conf)
+	}
+	if !reflect.DeepEqual(conf.WebConfig.AdminApiKeys, []string{"first", "second"}) {
+		t.Errorf("unexpected admin keys %v", conf.WebConfig.AdminApiKeys)
+	}
+	if !reflect.DeepEqual(conf.WebConfig.TrustedProxies, []string{"10.0.0.0/8"}) {
+		t.Errorf("unexpected trusted proxies %v", conf.WebConfig.TrustedProxies)
+	}
+	difficulty := conf.WebConfig.ProofOfWork.ActionDifficulty
+	if len(difficulty) != len(defaultConfig.WebConfig.ProofOfWork.ActionDifficulty)+1 ||
+		difficulty["poll_vote"] != 22 || difficulty["custom"] != 18 || difficulty["poll_vote_change"] != 20 {
+		t.Errorf("the map was not merged: %v", difficulty)
+	}
+	if defaultConfig.WebConfig.ProofOfWork.ActionDifficulty["poll_vote"] != 20 || defaultConfig.WebConfig.ProofOfWork.ActionDifficulty["custom"] != 0 {
+		t.Errorf("the defaults were modified")
+	}
+	if conf.WebConfig.EndpointsLimits.Polls.VotesEndpoint.UserRateLimit.Burst != 7 {
+		t.Errorf("nested field not overridden")
+	}
+}
+
+func TestApplyEnvOverridesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp_password")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := defaultConfig
+	err := applyEnvOverrides(&conf, lookupFrom(map[string]string{
+		"SPOLLS_EMAIL_CONFIG_SENDER_EMAIL_PASSWD":      "from-env",
+		"SPOLLS_EMAIL_CONFIG_SENDER_EMAIL_PASSWD_FILE": path,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.EmailConfig.SenderEmailPasswd != "s3cret" {
+		t.Errorf("expected the secret file to take precedence, got %q", conf.EmailConfig.SenderEmailPasswd)
+	}
+}
+
+func TestApplyEnvOverridesReportsAllErrors(t *testing.T) {
+	conf := defaultConfig
+	err := applyEnvOverrides(&conf, lookupFrom(map[string]string{
+		"SPOLLS_WEB_CONFIG_PORT":                  "-1",
+		"SPOLLS_DEBUG_MODE":                       "maybe",
+		"SPOLLS_WEB_CONFIG_RECAPTCHA_SECRET_FILE": filepath.Join(t.TempDir(), "missing"),
+	}))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, name := range []string{"SPOLLS_WEB_CONFIG_PORT", "SPOLLS_DEBUG_MODE", "SPOLLS_WEB_CONFIG_RECAPTCHA_SECRET_FILE"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("the error does not mention %s: %v", name, err)
+		}
+	}
+}
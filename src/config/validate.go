@@ -0,0 +1,304 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	htmltemplate "html/template"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// The largest proof-of-work difficulty the captcha package accepts
+const maxPowDifficulty = 32
+
+// FieldError describes an invalid configuration field.
+type FieldError struct {
+	// The path of the field, e.g. WebConfig.Port, or the environment variable it was overridden by
+	Field   string
+	Message string
+}
+
+// ValidationErrors lists all the invalid fields of a configuration.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "the configuration is invalid (%d errors):", len(e))
+	for _, fe := range e {
+		fmt.Fprintf(&sb, "\n  - %s: %s", fe.Field, fe.Message)
+	}
+	return sb.String()
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) fail(field string, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) port(field string, port int) {
+	if port < 1 || port > 65535 {
+		v.fail(field, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func (v *validator) nonNegative(field string, value int) {
+	if value < 0 {
+		v.fail(field, "must not be negative, got %d", value)
+	}
+}
+
+func (v *validator) positive(field string, value int) {
+	if value <= 0 {
+		v.fail(field, "must be positive, got %d", value)
+	}
+}
+
+func (v *validator) required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "must not be empty")
+	}
+}
+
+// httpUrl checks that value is an absolute http(s) URL.
+func (v *validator) httpUrl(field string, value string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.fail(field, "invalid URL: %v", err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail(field, "must be an absolute http or https URL, got '%s'", value)
+	}
+}
+
+// template checks that the file at path exists and is a valid html (or text) template, which can be executed
+// with EmailTemplateValues, i.e. refers only to their fields.
+func (v *validator) template(field string, path string, html bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		v.fail(field, "cannot read the template: %v", err)
+		return
+	}
+	if html {
+		var t *htmltemplate.Template
+		if t, err = htmltemplate.New(path).Parse(string(data)); err == nil {
+			err = t.Execute(io.Discard, EmailTemplateValues{})
+		}
+	} else {
+		var t *texttemplate.Template
+		if t, err = texttemplate.New(path).Parse(string(data)); err == nil {
+			err = t.Execute(io.Discard, EmailTemplateValues{})
+		}
+	}
+	if err != nil {
+		v.fail(field, "invalid template: %v", err)
+	}
+}
+
+func (v *validator) limits(field string, l Limits) {
+	v.nonNegative(field+".MaxBodySize", l.MaxBodySize)
+	v.rateLimit(field+".IpRateLimit", l.IpRateLimit)
+	v.rateLimit(field+".UserRateLimit", l.UserRateLimit)
+}
+
+func (v *validator) rateLimit(field string, r RateLimit) {
+	if r.PerMinute < 0 || r.PerMinute != r.PerMinute {
+		v.fail(field+".PerMinute", "must not be negative, got %v", r.PerMinute)
+	}
+	v.nonNegative(field+".Burst", r.Burst)
+}
+
+// Validate checks the whole configuration and returns ValidationErrors listing every invalid field, or nil.
+// The email templates are read from their paths and parsed.
+func (c *Configuration) Validate() error {
+	v := &validator{}
+	if _, err := mysql.ParseDSN(c.DbString); err != nil {
+		v.fail("DbString", "invalid DSN: %v", err)
+	}
+	c.EmailConfig.validate(v)
+	c.WebConfig.validate(v)
+	c.WorkersConfig.validate(v)
+	// the votes must outlive their confirmation tokens, or the emailed links stop working early
+	if retention, ttl := c.WorkersConfig.UnconfirmedVoteRetention, c.WebConfig.ConfirmationTokenTTL; retention > 0 && ttl > 0 && retention < ttl {
+		v.fail("WorkersConfig.UnconfirmedVoteRetention", "must not be lower than WebConfig.ConfirmationTokenTTL (%d), got %d", ttl, retention)
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (c *EmailConfiguration) validate(v *validator) {
+	switch c.Mailer {
+	case "smtp", "":
+		v.required("EmailConfig.SmtpHost", c.SmtpHost)
+		v.port("EmailConfig.SmtpPort", c.SmtpPort)
+	case "file":
+		v.required("EmailConfig.MailerDirectory", c.MailerDirectory)
+	case "log", "memory":
+	default:
+		v.fail("EmailConfig.Mailer", "must be one of smtp, file, log or memory, got '%s'", c.Mailer)
+	}
+	if _, err := mail.ParseAddress(c.SenderEmail); err != nil {
+		v.fail("EmailConfig.SenderEmail", "invalid address: %v", err)
+	}
+	if c.DkimPrivateKeyPath != "" {
+		v.required("EmailConfig.DkimDomain", c.DkimDomain)
+		v.required("EmailConfig.DkimSelector", c.DkimSelector)
+		if _, err := os.Stat(c.DkimPrivateKeyPath); err != nil {
+			v.fail("EmailConfig.DkimPrivateKeyPath", "cannot read the key: %v", err)
+		}
+	}
+	v.template("EmailConfig.EmailTemplatePath", c.EmailTemplatePath, true)
+	v.template("EmailConfig.EmailTextTemplatePath", c.EmailTextTemplatePath, false)
+	v.template("EmailConfig.WithdrawalEmailTemplatePath", c.WithdrawalEmailTemplatePath, true)
+	v.template("EmailConfig.WithdrawalEmailTextTemplatePath", c.WithdrawalEmailTextTemplatePath, false)
+}
+
+func (c *WebConfiguration) validate(v *validator) {
+	v.port("WebConfig.Port", int(c.Port))
+	if c.Protocol != "http" && c.Protocol != "https" {
+		v.fail("WebConfig.Protocol", "must be http or https, got '%s'", c.Protocol)
+	}
+	v.required("WebConfig.Domain", c.Domain)
+	if c.ApiPrefix != "" && (!strings.HasPrefix(c.ApiPrefix, "/") || strings.HasSuffix(c.ApiPrefix, "/")) {
+		v.fail("WebConfig.ApiPrefix", "must start with a slash and not end with one, got '%s'", c.ApiPrefix)
+	}
+	if c.MetricsPath != "" && !strings.HasPrefix(c.MetricsPath, "/") {
+		v.fail("WebConfig.MetricsPath", "must start with a slash, got '%s'", c.MetricsPath)
+	}
+	// the poll id is appended to the location, so it may be relative
+	v.required("WebConfig.TokenVerificationRedirectLocation", c.TokenVerificationRedirectLocation)
+	if _, err := url.Parse(c.TokenVerificationRedirectLocation); err != nil {
+		v.fail("WebConfig.TokenVerificationRedirectLocation", "invalid URL: %v", err)
+	}
+	c.CORS.validate(v)
+
+	switch c.CaptchaProvider {
+	case "recaptcha", "":
+		v.httpUrl("WebConfig.RecaptchaVerifyEndpoint", c.RecaptchaVerifyEndpoint)
+		v.required("WebConfig.RecaptchaSecret", c.RecaptchaSecret)
+	case "hcaptcha":
+		v.httpUrl("WebConfig.HCaptchaVerifyEndpoint", c.HCaptchaVerifyEndpoint)
+		v.required("WebConfig.HCaptchaSecret", c.HCaptchaSecret)
+	case "turnstile":
+		v.httpUrl("WebConfig.TurnstileVerifyEndpoint", c.TurnstileVerifyEndpoint)
+		v.required("WebConfig.TurnstileSecret", c.TurnstileSecret)
+	case "pow":
+		v.positive("WebConfig.ProofOfWork.ChallengeTTL", c.ProofOfWork.ChallengeTTL)
+		powDifficulty(v, "WebConfig.ProofOfWork.Difficulty", c.ProofOfWork.Difficulty)
+		for _, action := range sortedKeys(c.ProofOfWork.ActionDifficulty) {
+			powDifficulty(v, "WebConfig.ProofOfWork.ActionDifficulty."+action, c.ProofOfWork.ActionDifficulty[action])
+		}
+	case "disabled":
+	default:
+		v.fail("WebConfig.CaptchaProvider", "must be one of recaptcha, hcaptcha, turnstile, pow or disabled, got '%s'", c.CaptchaProvider)
+	}
+	if c.RecaptchaMinScore < 0 || c.RecaptchaMinScore > 1 {
+		v.fail("WebConfig.RecaptchaMinScore", "must be between 0 and 1, got %v", c.RecaptchaMinScore)
+	}
+	for _, route := range sortedKeys(c.CaptchaRoutes) {
+		if rc := c.CaptchaRoutes[route]; rc.MinScore < 0 || rc.MinScore > 1 {
+			v.fail("WebConfig.CaptchaRoutes."+route+".MinScore", "must be between 0 and 1, got %v", rc.MinScore)
+		}
+	}
+
+	for i, proxy := range c.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			v.fail(fmt.Sprintf("WebConfig.TrustedProxies[%d]", i), "must be an IP address or a CIDR, got '%s'", proxy)
+		}
+	}
+	for i, key := range c.AdminApiKeys {
+		if strings.TrimSpace(key) == "" {
+			v.fail(fmt.Sprintf("WebConfig.AdminApiKeys[%d]", i), "must not be empty")
+		}
+	}
+
+	v.nonNegative("WebConfig.ConfirmationResendCooldown", c.ConfirmationResendCooldown)
+	v.positive("WebConfig.HealthCheckTimeout", c.HealthCheckTimeout)
+	v.nonNegative("WebConfig.Timeouts.Read", c.Timeouts.Read)
+	v.nonNegative("WebConfig.Timeouts.ReadHeader", c.Timeouts.ReadHeader)
+	v.nonNegative("WebConfig.Timeouts.Write", c.Timeouts.Write)
+	v.nonNegative("WebConfig.Timeouts.Idle", c.Timeouts.Idle)
+	v.nonNegative("WebConfig.Timeouts.Shutdown", c.Timeouts.Shutdown)
+
+	polls := c.EndpointsLimits.Polls
+	v.limits("WebConfig.EndpointsLimits.Polls.ListEndpoint", polls.ListEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.PollEndpoint", polls.PollEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.ResultsEndpoint", polls.ResultsEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.VotesEndpoint", polls.VotesEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.ConfirmVoteEndpoint", polls.ConfirmVoteEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.ResendEndpoint", polls.ResendEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.OptOutEndpoint", polls.OptOutEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.WithdrawEndpoint", polls.WithdrawEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.ConfirmWithdrawalEndpoint", polls.ConfirmWithdrawalEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Polls.ChallengeEndpoint", polls.ChallengeEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Admin.PollsEndpoint", c.EndpointsLimits.Admin.PollsEndpoint)
+	v.limits("WebConfig.EndpointsLimits.Admin.OutboxEndpoint", c.EndpointsLimits.Admin.OutboxEndpoint)
+}
+
+// sortedKeys returns the keys of m in order, so that the errors are always reported in the same order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func powDifficulty(v *validator, field string, difficulty int) {
+	if difficulty < 1 || difficulty > maxPowDifficulty {
+		v.fail(field, "must be between 1 and %d, got %d", maxPowDifficulty, difficulty)
+	}
+}
+
+// validate checks that AccessControlAllowOrigin is either * or a list of origins separated by commas or spaces.
+func (c *CORSConfiguration) validate(v *validator) {
+	origins := c.Origins()
+	if len(origins) == 0 {
+		v.fail("WebConfig.CORS.AccessControlAllowOrigin", "must be * or a list of origins")
+		return
+	}
+	if len(origins) == 1 && (origins[0] == "*" || origins[0] == "null") {
+		return
+	}
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			v.fail("WebConfig.CORS.AccessControlAllowOrigin", "'%s' is not an origin (scheme://host[:port])", origin)
+		}
+	}
+}
+
+func (c *WorkersConfiguration) validate(v *validator) {
+	v.positive("WorkersConfig.Outbox.Workers", c.Outbox.Workers)
+	v.nonNegative("WorkersConfig.Outbox.PollInterval", c.Outbox.PollInterval)
+	v.positive("WorkersConfig.Outbox.BatchSize", c.Outbox.BatchSize)
+	v.positive("WorkersConfig.Outbox.MaxAttempts", c.Outbox.MaxAttempts)
+	v.nonNegative("WorkersConfig.Outbox.InitialBackoff", c.Outbox.InitialBackoff)
+	v.nonNegative("WorkersConfig.Outbox.MaxBackoff", c.Outbox.MaxBackoff)
+	if c.Outbox.MaxBackoff < c.Outbox.InitialBackoff {
+		v.fail("WorkersConfig.Outbox.MaxBackoff", "must not be lower than InitialBackoff (%d), got %d", c.Outbox.InitialBackoff, c.Outbox.MaxBackoff)
+	}
+	v.positive("WorkersConfig.Outbox.ClaimTimeout", c.Outbox.ClaimTimeout)
+	v.nonNegative("WorkersConfig.PollSchedulerInterval", c.PollSchedulerInterval)
+	v.nonNegative("WorkersConfig.CleanupInterval", c.CleanupInterval)
+	// 0 disables the removal, see RunCleanup
+	v.nonNegative("WorkersConfig.UnconfirmedVoteRetention", c.UnconfirmedVoteRetention)
+	v.nonNegative("WorkersConfig.InactiveUserRetention", c.InactiveUserRetention)
+	v.nonNegative("WorkersConfig.OutboxRetention", c.OutboxRetention)
+	v.nonNegative("WorkersConfig.RateLimitEvictionInterval", c.RateLimitEvictionInterval)
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validConfig returns the default configuration completed with the values it lacks, and templates written to dir.
+func validConfig(t *testing.T) Configuration {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	conf := defaultConfig
+	conf.EmailConfig.EmailTemplatePath = write("vote.html", "<a href=\"{{.Link}}\">{{.Receiver}}</a>{{if .IsChange}}{{.VoteOption}}{{end}}")
+	conf.EmailConfig.EmailTextTemplatePath = write("vote.txt", "{{.Link}} {{.PollTitle}}{{if .OptOutLink}} {{.OptOutLink}}{{end}}")
+	conf.EmailConfig.WithdrawalEmailTemplatePath = write("withdrawal.html", "<p>{{.Receiver}} {{.PollId}}</p>")
+	conf.EmailConfig.WithdrawalEmailTextTemplatePath = write("withdrawal.txt", "{{.ServiceName}} {{.Link}}")
+	conf.WebConfig.TokenVerificationRedirectLocation = "https://polls.example.com/poll/"
+	conf.WebConfig.RecaptchaSecret = "secret"
+	return conf
+}
+
+func fieldsOf(err error) []string {
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		return nil
+	}
+	var fields []string
+	for _, fe := range verrs {
+		fields = append(fields, fe.Field)
+	}
+	return fields
+}
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	conf := validConfig(t)
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateDefaultConfig(t *testing.T) {
+	conf := defaultConfig.clone()
+	conf.EmailConfig.EmailTemplatePath = "../../EmailTemplate.html"
+	conf.EmailConfig.EmailTextTemplatePath = "../../EmailTemplate.txt"
+	conf.EmailConfig.WithdrawalEmailTemplatePath = "../../WithdrawalEmailTemplate.html"
+	conf.EmailConfig.WithdrawalEmailTextTemplatePath = "../../WithdrawalEmailTemplate.txt"
+	// only the deployment specific fields are left to fill in the config file created on the first run
+	expected := "WebConfig.TokenVerificationRedirectLocation,WebConfig.RecaptchaSecret"
+	if got := fieldsOf(conf.Validate()); strings.Join(got, ",") != expected {
+		t.Fatalf("unexpected errors %v", got)
+	}
+}
+
+func TestValidateCollectsAllErrors(t *testing.T) {
+	conf := validConfig(t)
+	conf.DbString = "not a dsn"
+	conf.EmailConfig.SmtpPort = 70000
+	conf.EmailConfig.SenderEmail = "nobody"
+	conf.EmailConfig.WithdrawalEmailTextTemplatePath = filepath.Join(t.TempDir(), "missing.txt")
+	conf.WebConfig.Port = 0
+	conf.WebConfig.Protocol = "ftp"
+	conf.WebConfig.RecaptchaVerifyEndpoint = "www.google.com/recaptcha"
+	conf.WebConfig.CORS.AccessControlAllowOrigin = "https://a.example.com, https://b.example.com/path"
+	conf.WebConfig.TrustedProxies = []string{"10.0.0.0/8", "proxy"}
+	conf.WebConfig.EndpointsLimits.Polls.VotesEndpoint.UserRateLimit.Burst = -1
+	conf.WorkersConfig.Outbox.MaxBackoff = 1
+
+	err := conf.Validate()
+	expected := []string{
+		"DbString",
+		"EmailConfig.SmtpPort",
+		"EmailConfig.SenderEmail",
+		"EmailConfig.WithdrawalEmailTextTemplatePath",
+		"WebConfig.Port",
+		"WebConfig.Protocol",
+		"WebConfig.CORS.AccessControlAllowOrigin",
+		"WebConfig.RecaptchaVerifyEndpoint",
+		"WebConfig.TrustedProxies[1]",
+		"WebConfig.EndpointsLimits.Polls.VotesEndpoint.UserRateLimit.Burst",
+		"WorkersConfig.Outbox.MaxBackoff",
+	}
+	if got := fieldsOf(err); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected errors for\n%v\ngot\n%v", expected, got)
+	}
+	if !strings.Contains(err.Error(), "11 errors") || !strings.Contains(err.Error(), "  - WebConfig.Port: must be between 1 and 65535, got 0") {
+		t.Errorf("unexpected report:\n%v", err)
+	}
+}
+
+func TestValidateTemplateSyntax(t *testing.T) {
+	conf := validConfig(t)
+	path := filepath.Join(t.TempDir(), "broken.html")
+	if err := os.WriteFile(path, []byte("<p>{{.Username</p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf.EmailConfig.EmailTemplatePath = path
+	if got := fieldsOf(conf.Validate()); len(got) != 1 || got[0] != "EmailConfig.EmailTemplatePath" {
+		t.Fatalf("unexpected errors %v", got)
+	}
+}
+
+func TestValidateTemplateFields(t *testing.T) {
+	conf := validConfig(t)
+	dir := t.TempDir()
+	html := filepath.Join(dir, "unknown.html")
+	text := filepath.Join(dir, "unknown.txt")
+	if err := os.WriteFile(html, []byte("<p>{{.Username}}</p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(text, []byte("{{.ConfirmationLink}}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf.EmailConfig.WithdrawalEmailTemplatePath = html
+	conf.EmailConfig.EmailTextTemplatePath = text
+	expected := "EmailConfig.EmailTextTemplatePath,EmailConfig.WithdrawalEmailTemplatePath"
+	if got := fieldsOf(conf.Validate()); strings.Join(got, ",") != expected {
+		t.Fatalf("unexpected errors %v", got)
+	}
+}
+
+func TestValidateShippedTemplates(t *testing.T) {
+	conf := validConfig(t)
+	conf.EmailConfig.EmailTemplatePath = "../../EmailTemplate.html"
+	conf.EmailConfig.EmailTextTemplatePath = "../../EmailTemplate.txt"
+	conf.EmailConfig.WithdrawalEmailTemplatePath = "../../WithdrawalEmailTemplate.html"
+	conf.EmailConfig.WithdrawalEmailTextTemplatePath = "../../WithdrawalEmailTemplate.txt"
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateCORSOrigins(t *testing.T) {
+	cases := map[string]bool{
+		"*":                                 true,
+		"https://polls.example.com":         true,
+		"https://a.example.com http://b:80": true,
+		"":                                  false,
+		"polls.example.com":                 false,
+		"https://a.example.com/path":        false,
+		"*, https://a.example.com":          false,
+	}
+	for origins, valid := range cases {
+		v := &validator{}
+		(&CORSConfiguration{AccessControlAllowOrigin: origins}).validate(v)
+		if (len(v.errs) == 0) != valid {
+			t.Errorf("%q: expected valid=%v, got %v", origins, valid, v.errs)
+		}
+	}
+}
+
+func TestValidateProviderSpecificFields(t *testing.T) {
+	conf := validConfig(t)
+	conf.WebConfig.CaptchaProvider = "pow"
+	conf.WebConfig.RecaptchaSecret = ""
+	conf.WebConfig.ProofOfWork.ActionDifficulty = map[string]int{"poll_vote": 40, "poll_vote_change": 0}
+	expected := "WebConfig.ProofOfWork.ActionDifficulty.poll_vote,WebConfig.ProofOfWork.ActionDifficulty.poll_vote_change"
+	if got := fieldsOf(conf.Validate()); strings.Join(got, ",") != expected {
+		t.Fatalf("unexpected errors %v", got)
+	}
+
+	conf.WebConfig.CaptchaProvider = "captcha"
+	if got := fieldsOf(conf.Validate()); len(got) != 1 || got[0] != "WebConfig.CaptchaProvider" {
+		t.Fatalf("unexpected errors %v", got)
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	conf := validConfig(t)
+	conf.WorkersConfig.UnconfirmedVoteRetention = 0
+	conf.WorkersConfig.InactiveUserRetention = 0
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("0 disables the cleanup, unexpected error: %v", err)
+	}
+
+	conf.WebConfig.ConfirmationTokenTTL = 3600
+	conf.WorkersConfig.UnconfirmedVoteRetention = 60
+	conf.WorkersConfig.InactiveUserRetention = -1
+	expected := "WorkersConfig.InactiveUserRetention,WorkersConfig.UnconfirmedVoteRetention"
+	if got := fieldsOf(conf.Validate()); strings.Join(got, ",") != expected {
+		t.Fatalf("unexpected errors %v", got)
+	}
+}
+
+func TestCORSAllowOrigin(t *testing.T) {
+	cases := []struct {
+		allowed, origin, value string
+		varies                 bool
+	}{
+		{"*", "https://a.example.com", "*", false},
+		{"*", "", "*", false},
+		{"https://a.example.com", "https://a.example.com", "https://a.example.com", true},
+		{"https://a.example.com/", "https://a.example.com", "https://a.example.com", true},
+		{"https://a.example.com, https://b.example.com", "https://b.example.com", "https://b.example.com", true},
+		{"https://a.example.com https://b.example.com", "https://c.example.com", "", true},
+		{"https://a.example.com", "", "", true},
+	}
+	for _, c := range cases {
+		value, varies := (&CORSConfiguration{AccessControlAllowOrigin: c.allowed}).AllowOrigin(c.origin)
+		if value != c.value || varies != c.varies {
+			t.Errorf("AllowOrigin(%q) with %q = (%q, %v), expected (%q, %v)", c.origin, c.allowed, value, varies, c.value, c.varies)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+import "fmt"
+
+// DeleteExpiredConfirmations removes the confirmation tokens older than ttl seconds.
+func DeleteExpiredConfirmations(ttl int) (int64, error) {
+	res, err := Db.Exec("DELETE FROM "+TableConfirmations+" WHERE create_date < NOW() - INTERVAL ? SECOND;", ttl)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteExpiredConfirmations: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteOldOutboxEmails removes the sent and dead emails created more than retention seconds ago, along with the
+// links in their bodies.
+func DeleteOldOutboxEmails(retention int) (int64, error) {
+	res, err := Db.Exec("DELETE FROM "+TableOutbox+" WHERE status IN (?, ?) AND create_date < NOW() - INTERVAL ? SECOND;",
+		OutboxStatusSent, OutboxStatusDead, retention)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteOldOutboxEmails: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteUnconfirmedVotes removes the votes which have not been confirmed within retention seconds.
+func DeleteUnconfirmedVotes(retention int) (int64, error) {
+	res, err := Db.Exec("DELETE FROM "+TableVotes+" WHERE confirmed_at IS NULL AND create_date < NOW() - INTERVAL ? SECOND;", retention)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteUnconfirmedVotes: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteInactiveUsers removes the users older than retention seconds who have no votes left.
+func DeleteInactiveUsers(retention int) (int64, error) {
+	res, err := Db.Exec(`
+DELETE U FROM `+TableUsers+` U
+WHERE U.create_date < NOW() - INTERVAL ? SECOND
+	AND NOT EXISTS (SELECT 1 FROM `+TableVotes+` V WHERE V.user_id = U.id);`, retention)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteInactiveUsers: %v", err)
+	}
+	return res.RowsAffected()
+}
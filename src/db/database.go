@@ -1,7 +1,10 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"log"
 	"switch-polls-backend/config"
@@ -17,6 +20,9 @@ const (
 	TableOptions       = TablePrefix + "options"
 	TableExtras        = TablePrefix + "extras"
 	TableConfirmations = TablePrefix + "confirmations"
+	TableSelections    = TablePrefix + "vote_selections"
+	TableOutbox        = TablePrefix + "email_outbox"
+	TableOptOuts       = TablePrefix + "email_optouts"
 )
 
 func OpenDbInstance() *sql.DB {
@@ -46,15 +52,75 @@ func InitDb() {
 	UsersRepo = NewMySQLUsersRepository()
 	PollsRepo = NewMySQLPollsRepository()
 	VotesRepo = NewMySQLVotesRepository()
+	OutboxRepo = NewMySQLOutboxRepository()
 	UsersRepo.Init(Db)
 	PollsRepo.Init(Db)
 	VotesRepo.Init(Db)
+	OutboxRepo.Init(Db)
 	log.Println("Repositories initialised.")
 }
 
+// CloseDb closes the database, waiting for the started queries to finish.
+func CloseDb() {
+	if Db == nil {
+		return
+	}
+	if err := Db.Close(); err != nil {
+		log.Printf("CloseDb: %v", err)
+	}
+}
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	if Db == nil {
+		return fmt.Errorf("Ping: database not initialised")
+	}
+	return Db.PingContext(ctx)
+}
+
+const (
+	// How many times a transaction chosen as a deadlock victim is run
+	maxTransactionAttempts = 3
+	// ER_LOCK_DEADLOCK
+	mysqlErrDeadlock = 1213
+)
+
+// WithTransaction runs fn inside a transaction, which is committed if fn succeeds and rolled back otherwise.
+// If MySQL rolls the transaction back to resolve a deadlock, it is run again (fn must wrap the errors with %w for
+// the deadlock to be detected), so fn must not have side effects outside of tx.
+func WithTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runTransaction(db, fn)
+		if err == nil || !isDeadlock(err) || attempt >= maxTransactionAttempts {
+			return err
+		}
+		log.Printf("transaction deadlocked, retrying (attempt %d): %v", attempt, err)
+	}
+}
+
+func runTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("transaction rollback error: %v", rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// isDeadlock reports whether err means that MySQL rolled the transaction back to resolve a deadlock.
+func isDeadlock(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDeadlock
+}
+
 func GetConfirmationByToken(token string) (*Confirmation, error) {
 	var cnf Confirmation
-	err := Db.QueryRow("SELECT * FROM "+TableConfirmations+" WHERE token = ?;", token).Scan(&cnf.Token, &cnf.VoteId, &cnf.CreateDate)
+	err := Db.QueryRow("SELECT * FROM "+TableConfirmations+" WHERE token = ?;", token).Scan(&cnf.Token, &cnf.VoteId, &cnf.CreateDate, &cnf.Purpose)
 	if err != nil {
 		return nil, err
 	}
@@ -62,11 +128,24 @@ func GetConfirmationByToken(token string) (*Confirmation, error) {
 	return &cnf, nil
 }
 
-func PrepareResultsSummary(pollId int) (*ResultsSummary, error) {
+// GetConfirmationAge returns the number of seconds since the token was issued.
+func GetConfirmationAge(token string) (int, error) {
+	var age int
+	err := Db.QueryRow("SELECT TIMESTAMPDIFF(SECOND, create_date, NOW()) FROM "+TableConfirmations+" WHERE token = ?;", token).Scan(&age)
+	if err != nil {
+		return 0, fmt.Errorf("GetConfirmationAge: %v", err)
+	}
+	return age, nil
+}
+
+// PrepareResultsSummary counts the confirmed selections of every option. With firstPreferencesOnly
+// only the top choice of each ballot is counted, which gives the plurality result of ranked polls.
+func PrepareResultsSummary(pollId int, firstPreferencesOnly bool) (*ResultsSummary, error) {
 	res, err := Db.Query(`
-SELECT O.id, O.content, COUNT(*) 
-FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id 
-WHERE O.poll_id = ? AND confirmed_at IS NOT NULL GROUP BY O.id;`, pollId)
+SELECT O.id, O.content, COUNT(*)
+FROM `+TableSelections+` S INNER JOIN `+TableVotes+` V ON S.vote_id = V.id
+	INNER JOIN `+TableOptions+` O ON S.option_id = O.id
+WHERE O.poll_id = ? AND V.confirmed_at IS NOT NULL AND (S.position = 0 OR NOT ?) GROUP BY O.id;`, pollId, firstPreferencesOnly)
 	if err != nil {
 		log.Println("prepare results error", err)
 		return nil, err
@@ -81,7 +160,74 @@ WHERE O.poll_id = ? AND confirmed_at IS NOT NULL GROUP BY O.id;`, pollId)
 		}
 		summary = append(summary, result)
 	}
-	return &ResultsSummary{summary}, nil
+	if err = res.Err(); err != nil {
+		return nil, err
+	}
+
+	var ballots int
+	err = Db.QueryRow(`
+SELECT COUNT(*)
+FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id
+WHERE O.poll_id = ? AND V.confirmed_at IS NOT NULL;`, pollId).Scan(&ballots)
+	if err != nil {
+		return nil, err
+	}
+	return &ResultsSummary{Summary: summary, Ballots: ballots}, nil
+}
+
+// GetConfirmedBallots returns the option ids of every confirmed ballot in the poll in the order of preference.
+func GetConfirmedBallots(pollId int) ([][]int, error) {
+	rows, err := Db.Query(`
+SELECT S.vote_id, S.option_id
+FROM `+TableSelections+` S INNER JOIN `+TableVotes+` V ON S.vote_id = V.id
+	INNER JOIN `+TableOptions+` O ON S.option_id = O.id
+WHERE O.poll_id = ? AND V.confirmed_at IS NOT NULL ORDER BY S.vote_id, S.position;`, pollId)
+	if err != nil {
+		return nil, fmt.Errorf("GetConfirmedBallots %d: %v", pollId, err)
+	}
+	defer rows.Close()
+	ballots := make([][]int, 0)
+	lastVoteId := 0
+	for rows.Next() {
+		var voteId, optionId int
+		if err = rows.Scan(&voteId, &optionId); err != nil {
+			return nil, fmt.Errorf("GetConfirmedBallots %d: %v", pollId, err)
+		}
+		if voteId != lastVoteId {
+			ballots = append(ballots, make([]int, 0))
+			lastVoteId = voteId
+		}
+		ballots[len(ballots)-1] = append(ballots[len(ballots)-1], optionId)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetConfirmedBallots %d: %v", pollId, err)
+	}
+	return ballots, nil
+}
+
+// GetConfirmedScores returns the scores given to every option of the poll on the confirmed ballots.
+func GetConfirmedScores(pollId int) (map[int][]int, error) {
+	rows, err := Db.Query(`
+SELECT S.option_id, S.score
+FROM `+TableSelections+` S INNER JOIN `+TableVotes+` V ON S.vote_id = V.id
+	INNER JOIN `+TableOptions+` O ON S.option_id = O.id
+WHERE O.poll_id = ? AND V.confirmed_at IS NOT NULL AND S.score IS NOT NULL;`, pollId)
+	if err != nil {
+		return nil, fmt.Errorf("GetConfirmedScores %d: %v", pollId, err)
+	}
+	defer rows.Close()
+	scores := make(map[int][]int)
+	for rows.Next() {
+		var optionId, score int
+		if err = rows.Scan(&optionId, &score); err != nil {
+			return nil, fmt.Errorf("GetConfirmedScores %d: %v", pollId, err)
+		}
+		scores[optionId] = append(scores[optionId], score)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetConfirmedScores %d: %v", pollId, err)
+	}
+	return scores, nil
 }
 
 func CheckIfUserHasAlreadyVotedById(userId int, pollId int) (bool, error) {
@@ -101,8 +247,81 @@ WHERE O.poll_id = ? AND V.confirmed_at IS NOT NULL AND U.id = ?;`, pollId, userI
 	return res.Next(), res.Err()
 }
 
-func InsertToken(token string, voteId int) error {
-	res, err := Db.Exec("INSERT INTO "+TableConfirmations+"(token, vote_id) VALUES (?, ?);", token, voteId)
+// GetConfirmedVote returns the confirmed vote of the user in the poll or nil if there is none.
+func GetConfirmedVote(userId int, pollId int) (*PollVote, error) {
+	var vote PollVote
+	err := Db.QueryRow(`
+SELECT V.id, V.user_id, V.option_id, V.confirmed_at, V.create_date
+FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id
+WHERE O.poll_id = ? AND V.user_id = ? AND V.confirmed_at IS NOT NULL
+ORDER BY V.confirmed_at DESC LIMIT 1;`, pollId, userId).Scan(&vote.Id, &vote.UserId, &vote.OptionId, &vote.ConfirmedAt, &vote.CreateDate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("GetConfirmedVote of user %d in poll %d: %v", userId, pollId, err)
+	}
+	return &vote, nil
+}
+
+// GetPendingVote returns the newest unconfirmed vote of the user in the poll or nil if there is none.
+func GetPendingVote(userId int, pollId int) (*PollVote, error) {
+	var vote PollVote
+	err := Db.QueryRow(`
+SELECT V.id, V.user_id, V.option_id, V.confirmed_at, V.create_date, V.is_change
+FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id
+WHERE O.poll_id = ? AND V.user_id = ? AND V.confirmed_at IS NULL
+ORDER BY V.create_date DESC, V.id DESC LIMIT 1;`, pollId, userId).Scan(&vote.Id, &vote.UserId, &vote.OptionId, &vote.ConfirmedAt, &vote.CreateDate, &vote.IsChange)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("GetPendingVote of user %d in poll %d: %v", userId, pollId, err)
+	}
+	return &vote, nil
+}
+
+// GetLastTokenAge returns how many seconds ago the newest token of the given purpose was issued for the vote.
+// The second value is false if the vote has no such token.
+func GetLastTokenAge(voteId int, purpose ConfirmationPurpose) (int, bool, error) {
+	var age sql.NullInt64
+	err := Db.QueryRow("SELECT TIMESTAMPDIFF(SECOND, MAX(create_date), NOW()) FROM "+TableConfirmations+" WHERE vote_id = ? AND purpose = ?;", voteId, purpose).Scan(&age)
+	if err != nil {
+		return 0, false, fmt.Errorf("GetLastTokenAge of vote %d: %v", voteId, err)
+	}
+	return int(age.Int64), age.Valid, nil
+}
+
+// ConfirmVote marks the vote as confirmed and removes the earlier confirmed votes of the same user in the poll.
+func ConfirmVote(voteId int, confirmedAt int64) error {
+	var userId, pollId int
+	err := WithTransaction(Db, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+SELECT V.user_id, O.poll_id
+FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id
+WHERE V.id = ? FOR UPDATE;`, voteId).Scan(&userId, &pollId)
+		if err != nil {
+			return fmt.Errorf("ConfirmVote %d: %v", voteId, err)
+		}
+		_, err = tx.Exec(`
+DELETE V FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id
+WHERE O.poll_id = ? AND V.user_id = ? AND V.confirmed_at IS NOT NULL AND V.id <> ?;`, pollId, userId, voteId)
+		if err != nil {
+			return fmt.Errorf("ConfirmVote %d: cannot remove the replaced votes: %v", voteId, err)
+		}
+		_, err = tx.Exec("UPDATE "+TableVotes+" SET confirmed_at = ? WHERE id = ?;", confirmedAt, voteId)
+		if err != nil {
+			return fmt.Errorf("ConfirmVote %d: %v", voteId, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	votesConfirmed.Inc(pollLabel(pollId))
+	return nil
+}
+
+func InsertToken(token string, voteId int, purpose ConfirmationPurpose) error {
+	res, err := Db.Exec("INSERT INTO "+TableConfirmations+"(token, vote_id, purpose) VALUES (?, ?, ?);", token, voteId, purpose)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"testing"
+)
+
+func TestIsDeadlock(t *testing.T) {
+	cases := []struct {
+		err      error
+		deadlock bool
+	}{
+		{nil, false},
+		{errors.New("deadlock"), false},
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1205}, false},
+		{fmt.Errorf("CreateVote: %w", fmt.Errorf("enqueue email to a@b.c: %w", &mysql.MySQLError{Number: 1213})), true},
+		{fmt.Errorf("ConfirmVote: %v", &mysql.MySQLError{Number: 1213}), false},
+	}
+	for _, c := range cases {
+		if got := isDeadlock(c.err); got != c.deadlock {
+			t.Errorf("isDeadlock(%v) = %v, expected %v", c.err, got, c.deadlock)
+		}
+	}
+}
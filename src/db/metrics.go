@@ -0,0 +1,45 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"switch-polls-backend/metrics"
+)
+
+var (
+	votesCreated   = metrics.NewCounterVec("spolls_votes_created_total", "Votes cast, confirmed or not.", "poll")
+	votesConfirmed = metrics.NewCounterVec("spolls_votes_confirmed_total", "Votes confirmed with the emailed token.", "poll")
+)
+
+func init() {
+	pool := func(stat func(s sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			if Db == nil {
+				return 0
+			}
+			return stat(Db.Stats())
+		}
+	}
+	metrics.NewGaugeFunc("spolls_db_max_open_connections", "Maximum number of open connections to the database.",
+		pool(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	metrics.NewGaugeFunc("spolls_db_open_connections", "Established connections, both in use and idle.",
+		pool(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	metrics.NewGaugeFunc("spolls_db_in_use_connections", "Connections currently in use.",
+		pool(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	metrics.NewGaugeFunc("spolls_db_idle_connections", "Idle connections.",
+		pool(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	metrics.NewCounterFunc("spolls_db_wait_count_total", "Connections waited for.",
+		pool(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	metrics.NewCounterFunc("spolls_db_wait_duration_seconds_total", "Time blocked waiting for a new connection.",
+		pool(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	metrics.NewCounterFunc("spolls_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.",
+		pool(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	metrics.NewCounterFunc("spolls_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.",
+		pool(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	metrics.NewCounterFunc("spolls_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.",
+		pool(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
+
+func pollLabel(pollId int) string {
+	return strconv.Itoa(pollId)
+}
@@ -1,41 +1,64 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"log"
 	"os"
 	"switch-polls-backend/config"
+	"sync"
+)
+
+// The migrate instance used by ApplyMigrations, kept open for the readiness checks
+var (
+	migrations        *migrate.Migrate
+	migrationsMu      sync.Mutex
+	expectedDbVersion uint
 )
 
 func initMigrations() *migrate.Migrate {
-	workdir, err := os.Getwd()
-	dbInstance := OpenDbInstance()
+	migr, err := newMigrations()
 	if err != nil {
-		log.Fatalf("Failed to get working directory: %v", err)
+		log.Fatal(err)
 		return nil
 	}
+	return migr
+}
+
+func newMigrations() (*migrate.Migrate, error) {
+	workdir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %v", err)
+	}
+	dbInstance := OpenDbInstance()
 	driver, err := migratemysql.WithInstance(dbInstance, &migratemysql.Config{
 		MigrationsTable: TablePrefix + "schema_migrations",
 	})
 	if err != nil {
-		log.Fatalf("Failed to initialise migrate sql driver: %v", err)
-		return nil
+		dbInstance.Close()
+		return nil, fmt.Errorf("failed to initialise migrate sql driver: %v", err)
 	}
 
 	migr, err := migrate.NewWithDatabaseInstance("file://"+workdir+string(os.PathSeparator)+config.MigrationsPathRelative, config.Cfg.DatabaseConfig.DBName, driver)
 	if err != nil {
-		log.Fatalf("Failed to get migrate instance: %v", err)
-		return nil
+		driver.Close()
+		return nil, fmt.Errorf("failed to get migrate instance: %v", err)
 	}
-	return migr
+	return migr, nil
 }
 
 func ApplyMigrations() {
 	log.Println("Applying database migrations...")
 	migr := initMigrations()
-	defer migr.Close()
+	migrations = migr
 
 	ver, dirty, err := migr.Version()
 	if err == migrate.ErrNilVersion {
@@ -51,6 +74,7 @@ func ApplyMigrations() {
 	err = migr.Up()
 	if err == migrate.ErrNoChange {
 		log.Println("No changes to apply.")
+		expectedDbVersion = ver
 		return
 	} else if err != nil {
 		log.Fatalf("Failed to apply migrations: %v", err)
@@ -63,5 +87,60 @@ func ApplyMigrations() {
 		return
 	}
 	log.Printf("New db version: %d (dirtiness: %v)", ver, dirty)
+	expectedDbVersion = ver
 	log.Println("Migrations applied successfully.")
 }
+
+// CheckMigrations fails if the database schema is dirty or its version differs from the one ApplyMigrations
+// migrated it to, e.g. because the migrations were rolled back after the startup.
+func CheckMigrations(ctx context.Context) error {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if migrations == nil {
+		return fmt.Errorf("CheckMigrations: migrations have not been applied")
+	}
+	ver, dirty, err := migrations.Version()
+	if isBrokenConnection(err) {
+		// the instance holds a single connection, which is not reestablished by database/sql
+		log.Printf("CheckMigrations: reopening the migrate instance after %v", err)
+		migr, openErr := newMigrations()
+		if openErr != nil {
+			return fmt.Errorf("CheckMigrations: %v", openErr)
+		}
+		migrations.Close()
+		migrations = migr
+		ver, dirty, err = migrations.Version()
+	}
+	if err != nil {
+		return fmt.Errorf("CheckMigrations: %v", err)
+	}
+	if dirty {
+		return fmt.Errorf("CheckMigrations: version %d is dirty", ver)
+	}
+	if ver != expectedDbVersion {
+		return fmt.Errorf("CheckMigrations: version %d, expected %d", ver, expectedDbVersion)
+	}
+	return nil
+}
+
+// CloseMigrations releases the connection of the migrate instance.
+func CloseMigrations() {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if migrations == nil {
+		return
+	}
+	if srcErr, dbErr := migrations.Close(); srcErr != nil || dbErr != nil {
+		log.Printf("CloseMigrations: source: %v, database: %v", srcErr, dbErr)
+	}
+	migrations = nil
+}
+
+// isBrokenConnection reports whether err means that the connection of the migrate instance is no longer usable.
+func isBrokenConnection(err error) bool {
+	var dbErr *database.Error
+	if errors.As(err, &dbErr) {
+		err = dbErr.OrigErr
+	}
+	return errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4/database"
+	"testing"
+)
+
+func TestIsBrokenConnection(t *testing.T) {
+	cases := []struct {
+		err    error
+		broken bool
+	}{
+		{nil, false},
+		{errors.New("table doesn't exist"), false},
+		{&database.Error{OrigErr: sql.ErrConnDone}, true},
+		{&database.Error{OrigErr: driver.ErrBadConn}, true},
+		{&database.Error{OrigErr: mysql.ErrInvalidConn}, true},
+		{&database.Error{OrigErr: &mysql.MySQLError{Number: 1146}}, false},
+		{fmt.Errorf("wrapped: %w", sql.ErrConnDone), true},
+	}
+	for _, c := range cases {
+		if got := isBrokenConnection(c.err); got != c.broken {
+			t.Errorf("isBrokenConnection(%v) = %v, expected %v", c.err, got, c.broken)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"switch-polls-backend/tally"
 	"time"
 )
 
@@ -10,22 +11,91 @@ type OptionExtras struct {
 	OptionId int    `json:"-" db:"option_id"`
 	Type     string `json:"type" db:"type"`
 	Value    string `json:"value" db:"content"`
+	Position int    `json:"-" db:"position"`
 }
 
 type PollOption struct {
-	Id      int            `json:"id" db:"id"`
-	PollId  int            `json:"-" db:"poll_id"`
-	Content string         `json:"content" db:"content"`
-	Extras  []OptionExtras `json:"extras" db:"-"`
+	Id       int            `json:"id" db:"id"`
+	PollId   int            `json:"-" db:"poll_id"`
+	Content  string         `json:"content" db:"content"`
+	Position int            `json:"-" db:"position"`
+	Extras   []OptionExtras `json:"extras" db:"-"`
 }
 
 type Poll struct {
 	Id          int          `json:"id" db:"id"`
 	Title       string       `json:"title" db:"title"`
 	Description string       `json:"description" db:"description"`
-	Options     []PollOption `json:"options" db:"-"`
-	CreateDate  time.Time    `json:"-" db:"create_date"`
+	Options     []PollOption `json:"options,omitempty" db:"-"`
+	CreateDate  time.Time    `json:"create_date" db:"create_date"`
 	IsReadonly  bool         `json:"is_readonly" db:"is_readonly"`
+	// nil - the poll accepts votes since its creation
+	OpensAt *time.Time `json:"opens_at" db:"opens_at"`
+	// nil - the poll accepts votes until it is manually made read-only
+	ClosesAt      *time.Time `json:"closes_at" db:"closes_at"`
+	VotingMode    VotingMode `json:"voting_mode" db:"voting_mode"`
+	MinSelections int        `json:"min_selections" db:"min_selections"`
+	MaxSelections int        `json:"max_selections" db:"max_selections"`
+	// The number of options elected in ranked polls. More than one seat is counted with STV.
+	Seats int `json:"seats" db:"seats"`
+	// The scale of score polls
+	ScoreMin int `json:"score_min" db:"score_min"`
+	ScoreMax int `json:"score_max" db:"score_max"`
+}
+
+type VotingMode string
+
+const (
+	// exactly one option per ballot
+	VotingModeSingle VotingMode = "single"
+	// approval voting - between MinSelections and MaxSelections options per ballot
+	VotingModeMultiple VotingMode = "multiple"
+	// ranked-choice - between MinSelections and MaxSelections options ordered by preference
+	VotingModeRanked VotingMode = "ranked"
+	// score voting - every option gets a score between ScoreMin and ScoreMax
+	VotingModeScore VotingMode = "score"
+)
+
+type PollState int
+
+const (
+	PollStateAny PollState = iota
+	// accepts votes at the moment
+	PollStateOpen
+	// not read-only, but its opening time has not come yet
+	PollStateUpcoming
+	// read-only or past its closing time
+	PollStateReadonly
+)
+
+// State returns the state of the poll at the given moment.
+func (p *Poll) State(now time.Time) PollState {
+	if p.IsReadonly || (p.ClosesAt != nil && !now.Before(*p.ClosesAt)) {
+		return PollStateReadonly
+	}
+	if p.OpensAt != nil && now.Before(*p.OpensAt) {
+		return PollStateUpcoming
+	}
+	return PollStateOpen
+}
+
+// PollsFilter describes which polls should be returned by ListPolls and in what order.
+type PollsFilter struct {
+	State         PollState
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Descending    bool
+	Limit         int
+	Offset        int
+	// If set, only the polls following the cursor in the requested order are returned.
+	After       *PollsCursor
+	WithOptions bool
+}
+
+// PollsCursor points at a poll in the (create_date, id) ordering used by ListPolls.
+type PollsCursor struct {
+	CreateDate time.Time
+	Id         int
 }
 
 type PollVote struct {
@@ -34,22 +104,53 @@ type PollVote struct {
 	OptionId    int           `db:"option_id"`
 	ConfirmedAt sql.NullInt64 `db:"confirmed_at"`
 	CreateDate  time.Time     `db:"create_date"`
+	// Whether the vote was cast with /vote/change over a confirmed vote, which it replaces once confirmed
+	IsChange bool `db:"is_change"`
+	// The options chosen on the ballot. OptionId is always the first of them.
+	Selections []VoteSelection `db:"-"`
+}
+
+type VoteSelection struct {
+	Id       int `db:"id"`
+	VoteId   int `db:"vote_id"`
+	OptionId int `db:"option_id"`
+	Position int `db:"position"`
+	// Only in score polls
+	Score *int `db:"score"`
 }
 
 type Confirmation struct {
-	Token      string    `db:"token"`
-	VoteId     int       `db:"vote_id"`
-	CreateDate time.Time `db:"create_date"`
+	Token      string              `db:"token"`
+	VoteId     int                 `db:"vote_id"`
+	CreateDate time.Time           `db:"create_date"`
+	Purpose    ConfirmationPurpose `db:"purpose"`
 }
 
+type ConfirmationPurpose string
+
+const (
+	// confirms an unconfirmed vote, replacing the earlier confirmed vote of the user in the poll
+	ConfirmationPurposeVote ConfirmationPurpose = "vote"
+	// confirms the withdrawal of a confirmed vote
+	ConfirmationPurposeWithdraw ConfirmationPurpose = "withdraw"
+)
+
 type ResultsSummary struct {
 	Summary []VoteResult `json:"summary"`
+	// The number of confirmed ballots
+	Ballots int `json:"ballots"`
+	// Only for single-seat ranked polls
+	InstantRunoff *tally.IRVResult `json:"instant_runoff,omitempty"`
+	// Only for multi-seat ranked polls
+	SingleTransferableVote *tally.STVResult `json:"stv,omitempty"`
 }
 
 type VoteResult struct {
 	Id      int    `json:"id"`
 	Content string `json:"content"`
 	Count   int    `json:"count"`
+	// Only in score polls
+	Score *tally.ScoreStats `json:"score,omitempty"`
 }
 
 type User struct {
@@ -57,3 +158,28 @@ type User struct {
 	Email      string    `json:"email" db:"email"`
 	CreateDate time.Time `json:"-" db:"create_date"`
 }
+
+type OutboxStatus string
+
+const (
+	OutboxStatusPending OutboxStatus = "pending"
+	OutboxStatusSending OutboxStatus = "sending"
+	OutboxStatusSent    OutboxStatus = "sent"
+	// delivery failed too many times
+	OutboxStatusDead OutboxStatus = "dead"
+)
+
+type OutboxEmail struct {
+	Id            int          `json:"id" db:"id"`
+	Recipient     string       `json:"recipient" db:"recipient"`
+	SourceIp      string       `json:"-" db:"source_ip"`
+	Subject       string       `json:"subject" db:"subject"`
+	Body          string       `json:"-" db:"body"`
+	TextBody      string       `json:"-" db:"text_body"`
+	Status        OutboxStatus `json:"status" db:"status"`
+	Attempts      int          `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     string       `json:"last_error" db:"last_error"`
+	CreateDate    time.Time    `json:"create_date" db:"create_date"`
+	SentAt        *time.Time   `json:"sent_at" db:"sent_at"`
+}
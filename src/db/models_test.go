@@ -0,0 +1,37 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollState(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Second), now.Add(time.Second)
+	cases := []struct {
+		name       string
+		opensAt    *time.Time
+		closesAt   *time.Time
+		isReadonly bool
+		expected   PollState
+	}{
+		{"no schedule", nil, nil, false, PollStateOpen},
+		{"read-only", nil, nil, true, PollStateReadonly},
+		{"opened", &before, nil, false, PollStateOpen},
+		{"opens now", &now, nil, false, PollStateOpen},
+		{"opens later", &after, nil, false, PollStateUpcoming},
+		{"closes later", nil, &after, false, PollStateOpen},
+		{"closes now", nil, &now, false, PollStateReadonly},
+		{"closed", nil, &before, false, PollStateReadonly},
+		{"within the schedule", &before, &after, false, PollStateOpen},
+		{"upcoming read-only", &after, nil, true, PollStateReadonly},
+		{"open read-only", &before, &after, true, PollStateReadonly},
+		{"opens and closes now", &now, &now, false, PollStateReadonly},
+	}
+	for _, c := range cases {
+		poll := Poll{OpensAt: c.opensAt, ClosesAt: c.closesAt, IsReadonly: c.isReadonly}
+		if got := poll.State(now); got != c.expected {
+			t.Errorf("%s: expected state %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package db
+
+import "fmt"
+
+// OptOut stops the confirmation emails to the address. Opting out more than once is not an error.
+func OptOut(email string) error {
+	_, err := Db.Exec("INSERT IGNORE INTO "+TableOptOuts+"(email) VALUES (?);", email)
+	if err != nil {
+		return fmt.Errorf("OptOut %s: %v", email, err)
+	}
+	return nil
+}
+
+// IsOptedOut checks whether the owner of the address has opted out of the confirmation emails.
+func IsOptedOut(email string) (bool, error) {
+	res, err := Db.Query("SELECT 1 FROM "+TableOptOuts+" WHERE email = ?;", email)
+	if err != nil {
+		return false, fmt.Errorf("IsOptedOut %s: %v", email, err)
+	}
+	defer res.Close()
+	return res.Next(), res.Err()
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"switch-polls-backend/config"
+	"unicode/utf8"
+)
+
+type MySQLOutboxRepository struct {
+	Db *sql.DB
+}
+
+func NewMySQLOutboxRepository() MySQLOutboxRepository {
+	return MySQLOutboxRepository{}
+}
+
+func (m *MySQLOutboxRepository) Init(db *sql.DB) {
+	m.Db = db
+}
+
+// Enqueue adds the email to the outbox. It is delivered by the outbox worker.
+func (m *MySQLOutboxRepository) Enqueue(email OutboxEmail) error {
+	return WithTransaction(m.Db, func(tx *sql.Tx) error {
+		return enqueueEmail(tx, email)
+	})
+}
+
+// EmailThrottledError is returned instead of enqueueing an email which would exceed the limits in
+// EmailConfiguration.Throttling.
+type EmailThrottledError struct {
+	// In how many seconds the email can be enqueued
+	RetryAfter int
+}
+
+func (e *EmailThrottledError) Error() string {
+	return fmt.Sprintf("email limit exceeded, retry after %d seconds", e.RetryAfter)
+}
+
+// enqueueEmail adds the email to the outbox within tx, unless its recipient or its source address has exceeded
+// the email limits. The recent emails counted against the limits are locked until tx ends, so that concurrent
+// requests cannot all see a count below the limit. The gap locks taken by the count can make two concurrent first
+// emails to the same recipient deadlock; the errors are wrapped, so that WithTransaction runs the loser again and
+// it sees the email of the winner.
+func enqueueEmail(tx *sql.Tx, email OutboxEmail) error {
+	retryAfter, err := emailRetryAfter(tx, email)
+	if err != nil {
+		return fmt.Errorf("enqueue email to %s: %w", email.Recipient, err)
+	}
+	if retryAfter > 0 {
+		return &EmailThrottledError{RetryAfter: retryAfter}
+	}
+
+	var sourceIp interface{}
+	if email.SourceIp != "" {
+		sourceIp = email.SourceIp
+	}
+	_, err = tx.Exec("INSERT INTO "+TableOutbox+" (recipient, source_ip, subject, body, text_body) VALUES (?, ?, ?, ?, ?);",
+		email.Recipient, sourceIp, email.Subject, email.Body, email.TextBody)
+	if err != nil {
+		return fmt.Errorf("enqueue email to %s: %w", email.Recipient, err)
+	}
+	return nil
+}
+
+// emailRetryAfter returns in how many seconds the email can be sent or 0 if no limit has been reached.
+func emailRetryAfter(tx *sql.Tx, email OutboxEmail) (int, error) {
+	limits := config.Cfg.EmailConfig.Throttling
+	quotas := []struct {
+		limit  int
+		window int
+		column string
+		value  string
+	}{
+		{limits.RecipientHourly, 3600, "recipient", email.Recipient},
+		{limits.RecipientDaily, 24 * 3600, "recipient", email.Recipient},
+		{limits.SourceIpHourly, 3600, "source_ip", email.SourceIp},
+		{limits.SourceIpDaily, 24 * 3600, "source_ip", email.SourceIp},
+	}
+	retryAfter := 0
+	for _, q := range quotas {
+		if q.limit <= 0 || q.value == "" {
+			continue
+		}
+		var count, oldest int
+		err := tx.QueryRow(`
+SELECT COUNT(*), COALESCE(TIMESTAMPDIFF(SECOND, MIN(create_date), NOW()), 0)
+FROM `+TableOutbox+` WHERE `+q.column+` = ? AND create_date > NOW() - INTERVAL ? SECOND FOR UPDATE;`, q.value, q.window).Scan(&count, &oldest)
+		if err != nil {
+			return 0, err
+		}
+		if wait := q.window - oldest; count >= q.limit && wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, nil
+}
+
+// Claim marks at most limit emails due for delivery as being sent by the caller and returns them. The emails
+// stuck in sending for longer than claimTimeout seconds (e.g. after a crash) are claimed again, which counts as a
+// failed attempt, and moved to the dead letters once they have used up maxAttempts.
+func (m *MySQLOutboxRepository) Claim(claimId string, limit int, claimTimeout int, maxAttempts int) ([]OutboxEmail, error) {
+	_, err := m.Db.Exec(`
+UPDATE `+TableOutbox+` SET status = ?, attempts = attempts + 1, last_error = ?, claim_id = NULL
+WHERE status = ? AND claimed_at < NOW() - INTERVAL ? SECOND AND attempts + 1 >= ?;`,
+		OutboxStatusDead, claimTimeoutError, OutboxStatusSending, claimTimeout, maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("Claim %s: %v", claimId, err)
+	}
+	// attempts is assigned first, so that it still sees the previous status
+	_, err = m.Db.Exec(`
+UPDATE `+TableOutbox+` SET attempts = attempts + IF(status = ?, 1, 0), status = ?, claim_id = ?, claimed_at = NOW()
+WHERE (status = ? AND next_attempt_at <= NOW()) OR (status = ? AND claimed_at < NOW() - INTERVAL ? SECOND)
+ORDER BY id LIMIT ?;`, OutboxStatusSending, OutboxStatusSending, claimId, OutboxStatusPending, OutboxStatusSending, claimTimeout, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Claim %s: %v", claimId, err)
+	}
+
+	rows, err := m.Db.Query("SELECT id, recipient, subject, body, COALESCE(text_body, ''), attempts FROM "+TableOutbox+" WHERE claim_id = ? AND status = ?;", claimId, OutboxStatusSending)
+	if err != nil {
+		return nil, fmt.Errorf("Claim %s: %v", claimId, err)
+	}
+	defer rows.Close()
+	emails := make([]OutboxEmail, 0)
+	for rows.Next() {
+		email := OutboxEmail{Status: OutboxStatusSending}
+		if err = rows.Scan(&email.Id, &email.Recipient, &email.Subject, &email.Body, &email.TextBody, &email.Attempts); err != nil {
+			return nil, fmt.Errorf("Claim %s: %v", claimId, err)
+		}
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Claim %s: %v", claimId, err)
+	}
+	return emails, nil
+}
+
+func (m *MySQLOutboxRepository) MarkSent(id int) error {
+	_, err := m.Db.Exec("UPDATE "+TableOutbox+" SET status = ?, attempts = attempts + 1, sent_at = NOW(), claim_id = NULL WHERE id = ?;", OutboxStatusSent, id)
+	if err != nil {
+		return fmt.Errorf("MarkSent %d: %v", id, err)
+	}
+	return nil
+}
+
+// The length of the last_error column, in characters
+const maxLastErrorLength = 1024
+
+// The last error of the emails whose delivery did not finish within the claim timeout
+const claimTimeoutError = "claim timed out"
+
+// truncateRunes returns s, with its invalid UTF-8 sequences replaced, cut to at most max characters.
+func truncateRunes(s string, max int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max])
+}
+
+// MarkFailed records the failed delivery attempt. The email is retried after retryAfter seconds
+// or moved to the dead letters if dead is set.
+func (m *MySQLOutboxRepository) MarkFailed(id int, sendErr error, retryAfter int, dead bool) error {
+	status := OutboxStatusPending
+	if dead {
+		status = OutboxStatusDead
+	}
+	msg := truncateRunes(sendErr.Error(), maxLastErrorLength)
+	_, err := m.Db.Exec(`
+UPDATE `+TableOutbox+` SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = NOW() + INTERVAL ? SECOND, claim_id = NULL
+WHERE id = ?;`, status, msg, retryAfter, id)
+	if err != nil {
+		return fmt.Errorf("MarkFailed %d: %v", id, err)
+	}
+	return nil
+}
+
+// List returns the most recent emails in the given statuses, without their bodies.
+func (m *MySQLOutboxRepository) List(statuses []OutboxStatus, limit int) ([]OutboxEmail, error) {
+	if len(statuses) == 0 {
+		return make([]OutboxEmail, 0), nil
+	}
+	args := make([]interface{}, 0, len(statuses)+1)
+	for _, s := range statuses {
+		args = append(args, s)
+	}
+	args = append(args, limit)
+	rows, err := m.Db.Query(`
+SELECT id, recipient, subject, status, attempts, next_attempt_at, last_error, create_date, sent_at
+FROM `+TableOutbox+` WHERE status IN (?`+strings.Repeat(", ?", len(statuses)-1)+`) ORDER BY id DESC LIMIT ?;`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("List %v: %v", statuses, err)
+	}
+	defer rows.Close()
+	emails := make([]OutboxEmail, 0)
+	for rows.Next() {
+		var email OutboxEmail
+		err = rows.Scan(&email.Id, &email.Recipient, &email.Subject, &email.Status, &email.Attempts, &email.NextAttemptAt, &email.LastError, &email.CreateDate, &email.SentAt)
+		if err != nil {
+			return nil, fmt.Errorf("List %v: %v", statuses, err)
+		}
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("List %v: %v", statuses, err)
+	}
+	return emails, nil
+}
+
+// CountByStatus returns the number of emails in every status.
+func (m *MySQLOutboxRepository) CountByStatus() (map[OutboxStatus]int, error) {
+	rows, err := m.Db.Query("SELECT status, COUNT(*) FROM " + TableOutbox + " GROUP BY status;")
+	if err != nil {
+		return nil, fmt.Errorf("CountByStatus: %v", err)
+	}
+	defer rows.Close()
+	counts := make(map[OutboxStatus]int)
+	for rows.Next() {
+		var status OutboxStatus
+		var count int
+		if err = rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("CountByStatus: %v", err)
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		max   int
+		want  string
+	}{
+		{"short", "timeout", 10, "timeout"},
+		{"exact", "timeout", 7, "timeout"},
+		{"ascii", "timeout", 4, "time"},
+		{"multi-byte boundary", "żółć", 2, "żó"},
+		{"emoji", "a😀b", 2, "a😀"},
+		{"invalid utf-8", "a\xffb", 10, "a�b"},
+		{"long", strings.Repeat("ł", 2000), 1024, strings.Repeat("ł", 1024)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateRunes(tt.input, tt.max)
+			if got != tt.want {
+				t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.input, tt.max, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateRunes(%q, %d) = %q is not valid UTF-8", tt.input, tt.max, got)
+			}
+		})
+	}
+}
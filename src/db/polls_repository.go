@@ -2,7 +2,16 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPollNotFound          = errors.New("poll not found")
+	ErrPollHasConfirmedVotes = errors.New("the poll already has confirmed votes")
+	ErrInvalidPollOption     = errors.New("the option does not belong to the poll")
 )
 
 type MySQLPollsRepository struct {
@@ -17,14 +26,16 @@ func (m *MySQLPollsRepository) Init(db *sql.DB) {
 	m.Db = db
 }
 
-//GetPoll does not support default values!
+// GetPoll does not support default values!
 // recursiveMode - return the whole poll structure, together with pollOptions and optionExtras
 func (m *MySQLPollsRepository) GetPoll(cond Poll, recursiveMode bool) (*Poll, error) {
 	condition, values := ObjectToSQLCondition(AND, cond, false)
 	row := m.Db.QueryRow("SELECT * FROM "+TablePolls+" WHERE "+condition+";", values...)
 
 	var poll Poll
-	if err := row.Scan(&poll.Id, &poll.Title, &poll.Description, &poll.CreateDate, &poll.IsReadonly); err != nil {
+	if err := row.Scan(&poll.Id, &poll.Title, &poll.Description, &poll.CreateDate, &poll.IsReadonly, &poll.OpensAt, &poll.ClosesAt,
+		&poll.VotingMode, &poll.MinSelections, &poll.MaxSelections, &poll.Seats,
+		&poll.ScoreMin, &poll.ScoreMax); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("GetPoll: poll %v not found", cond)
 		}
@@ -41,11 +52,81 @@ func (m *MySQLPollsRepository) GetPoll(cond Poll, recursiveMode bool) (*Poll, er
 	return &poll, nil
 }
 
+// ListPolls returns the polls matching the filter ordered by their creation date.
+func (m *MySQLPollsRepository) ListPolls(filter PollsFilter) ([]Poll, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	now := time.Now()
+	switch filter.State {
+	case PollStateOpen:
+		conditions = append(conditions, "`is_readonly` = FALSE AND (`opens_at` IS NULL OR `opens_at` <= ?) AND (`closes_at` IS NULL OR `closes_at` > ?)")
+		args = append(args, now, now)
+	case PollStateUpcoming:
+		conditions = append(conditions, "`is_readonly` = FALSE AND `opens_at` > ? AND (`closes_at` IS NULL OR `closes_at` > ?)")
+		args = append(args, now, now)
+	case PollStateReadonly:
+		conditions = append(conditions, "(`is_readonly` = TRUE OR `closes_at` <= ?)")
+		args = append(args, now)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "`create_date` >= ?")
+		args = append(args, filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "`create_date` < ?")
+		args = append(args, filter.CreatedBefore)
+	}
+	order, cmp := "ASC", ">"
+	if filter.Descending {
+		order, cmp = "DESC", "<"
+	}
+	if filter.After != nil {
+		conditions = append(conditions, "(`create_date` "+cmp+" ? OR (`create_date` = ? AND `id` "+cmp+" ?))")
+		args = append(args, filter.After.CreateDate, filter.After.CreateDate, filter.After.Id)
+	}
+
+	query := "SELECT * FROM " + TablePolls
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY `create_date` " + order + ", `id` " + order + " LIMIT ? OFFSET ?;"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := m.Db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ListPolls %v: %v", filter, err)
+	}
+	defer rows.Close()
+	polls := make([]Poll, 0)
+	for rows.Next() {
+		var poll Poll
+		if err = rows.Scan(&poll.Id, &poll.Title, &poll.Description, &poll.CreateDate, &poll.IsReadonly, &poll.OpensAt, &poll.ClosesAt,
+			&poll.VotingMode, &poll.MinSelections, &poll.MaxSelections, &poll.Seats,
+			&poll.ScoreMin, &poll.ScoreMax); err != nil {
+			return nil, fmt.Errorf("ListPolls %v: %v", filter, err)
+		}
+		polls = append(polls, poll)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListPolls %v: %v", filter, err)
+	}
+
+	if filter.WithOptions {
+		for i := range polls {
+			polls[i].Options, err = m.GetPollOptions(polls[i].Id, true)
+			if err != nil {
+				return nil, fmt.Errorf("ListPolls %v: failed to get the options of poll %d: %v", filter, polls[i].Id, err)
+			}
+		}
+	}
+	return polls, nil
+}
+
 func (m *MySQLPollsRepository) GetPollOption(cond PollOption, recursiveMode bool) (PollOption, error) {
 	conditionString, args := ObjectToSQLCondition(AND, cond, false)
 	row := m.Db.QueryRow("SELECT * FROM "+TableOptions+" WHERE "+conditionString, args...)
 	var option PollOption
-	if err := row.Scan(&option.Id, &option.PollId, &option.Content); err != nil {
+	if err := row.Scan(&option.Id, &option.PollId, &option.Content, &option.Position); err != nil {
 		return PollOption{}, fmt.Errorf("GetPollOption %v: %v", cond, err)
 	}
 	if recursiveMode {
@@ -63,7 +144,7 @@ func (m *MySQLPollsRepository) GetPollOption(cond PollOption, recursiveMode bool
 }
 
 func (m *MySQLPollsRepository) GetPollOptions(pollId int, recursiveMode bool) ([]PollOption, error) {
-	rows, err := m.Db.Query("SELECT * FROM "+TableOptions+" AS O WHERE O.poll_id = ?;", pollId)
+	rows, err := m.Db.Query("SELECT * FROM "+TableOptions+" AS O WHERE O.poll_id = ? ORDER BY O.position, O.id;", pollId)
 	if err != nil {
 		return nil, fmt.Errorf("GetPollOptions %d: %v", pollId, err)
 	}
@@ -71,7 +152,7 @@ func (m *MySQLPollsRepository) GetPollOptions(pollId int, recursiveMode bool) ([
 	options := make([]PollOption, 0)
 	for rows.Next() {
 		var opt PollOption
-		if err = rows.Scan(&opt.Id, &opt.PollId, &opt.Content); err != nil {
+		if err = rows.Scan(&opt.Id, &opt.PollId, &opt.Content, &opt.Position); err != nil {
 			return nil, fmt.Errorf("GetPollOptions %d: %v", pollId, err)
 		}
 
@@ -91,7 +172,7 @@ func (m *MySQLPollsRepository) GetPollOptions(pollId int, recursiveMode bool) ([
 }
 
 func (m *MySQLPollsRepository) GetOptionExtras(optionId int) ([]OptionExtras, error) {
-	res, err := Db.Query("SELECT * FROM "+TableExtras+" WHERE option_id = ?;", optionId)
+	res, err := Db.Query("SELECT * FROM "+TableExtras+" WHERE option_id = ? ORDER BY position, id;", optionId)
 	if err != nil {
 		return make([]OptionExtras, 0), fmt.Errorf("GetOptionExtras %d: %v", optionId, err)
 	}
@@ -101,7 +182,7 @@ func (m *MySQLPollsRepository) GetOptionExtras(optionId int) ([]OptionExtras, er
 	for res.Next() {
 		var extra OptionExtras
 		var tmp int
-		err = res.Scan(&extra.Id, &tmp, &extra.Type, &extra.Value)
+		err = res.Scan(&extra.Id, &tmp, &extra.Type, &extra.Value, &extra.Position)
 
 		if err != nil {
 			return make([]OptionExtras, 0), fmt.Errorf("GetOptionExtras %d: %v", optionId, err)
@@ -111,10 +192,177 @@ func (m *MySQLPollsRepository) GetOptionExtras(optionId int) ([]OptionExtras, er
 	return extras, nil
 }
 
+// CreatePoll inserts the poll together with its options and their extras in a single transaction.
+// The order of the options and extras in the given slices is preserved.
 func (m *MySQLPollsRepository) CreatePoll(poll Poll) (*Poll, error) {
-	panic("implement me")
+	var pollId int64
+	err := WithTransaction(m.Db, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO "+TablePolls+" (`title`, `description`, `is_readonly`, `opens_at`, `closes_at`, `voting_mode`, `min_selections`, `max_selections`, `seats`, `score_min`, `score_max`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+			poll.Title, poll.Description, poll.IsReadonly, poll.OpensAt, poll.ClosesAt, poll.VotingMode, poll.MinSelections, poll.MaxSelections, poll.Seats, poll.ScoreMin, poll.ScoreMax)
+		if err != nil {
+			return err
+		}
+		if pollId, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		for i, opt := range poll.Options {
+			if err = insertPollOption(tx, int(pollId), i, opt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreatePoll %v: %w", poll, err)
+	}
+	return m.GetPoll(Poll{Id: int(pollId)}, true)
 }
 
+// UpdatePoll replaces the whole poll structure in a single transaction. Options with an id are updated,
+// options without one are created and the ones missing from poll.Options are deleted. The positions of
+// the options and extras follow their order in the given slices.
+// Returns ErrPollHasConfirmedVotes if anybody has already confirmed their vote.
 func (m *MySQLPollsRepository) UpdatePoll(poll Poll) (*Poll, error) {
-	panic("implement me")
+	err := WithTransaction(m.Db, func(tx *sql.Tx) error {
+		if err := lockPollForEditing(tx, poll.Id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE "+TablePolls+" SET `title` = ?, `description` = ?, `is_readonly` = ?, `opens_at` = ?, `closes_at` = ?, `voting_mode` = ?, `min_selections` = ?, `max_selections` = ?, `seats` = ?, `score_min` = ?, `score_max` = ? WHERE `id` = ?;",
+			poll.Title, poll.Description, poll.IsReadonly, poll.OpensAt, poll.ClosesAt, poll.VotingMode, poll.MinSelections, poll.MaxSelections, poll.Seats, poll.ScoreMin, poll.ScoreMax, poll.Id)
+		if err != nil {
+			return err
+		}
+
+		existing, err := getPollOptionIds(tx, poll.Id)
+		if err != nil {
+			return err
+		}
+		kept := make(map[int]bool)
+		for i, opt := range poll.Options {
+			if opt.Id == 0 {
+				if err = insertPollOption(tx, poll.Id, i, opt); err != nil {
+					return err
+				}
+				continue
+			}
+			if !existing[opt.Id] || kept[opt.Id] {
+				return fmt.Errorf("option %d: %w", opt.Id, ErrInvalidPollOption)
+			}
+			kept[opt.Id] = true
+			if _, err = tx.Exec("UPDATE "+TableOptions+" SET `content` = ?, `position` = ? WHERE `id` = ?;", opt.Content, i, opt.Id); err != nil {
+				return err
+			}
+			if _, err = tx.Exec("DELETE FROM "+TableExtras+" WHERE `option_id` = ?;", opt.Id); err != nil {
+				return err
+			}
+			if err = insertOptionExtras(tx, opt.Id, opt.Extras); err != nil {
+				return err
+			}
+		}
+		for id := range existing {
+			if kept[id] {
+				continue
+			}
+			if _, err = tx.Exec("DELETE FROM "+TableOptions+" WHERE `id` = ?;", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("UpdatePoll %d: %w", poll.Id, err)
+	}
+	return m.GetPoll(Poll{Id: poll.Id}, true)
+}
+
+// DeletePoll removes the poll with all of its options, extras and unconfirmed votes.
+// Returns ErrPollHasConfirmedVotes if anybody has already confirmed their vote.
+func (m *MySQLPollsRepository) DeletePoll(pollId int) error {
+	err := WithTransaction(m.Db, func(tx *sql.Tx) error {
+		if err := lockPollForEditing(tx, pollId); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM "+TablePolls+" WHERE `id` = ?;", pollId)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("DeletePoll %d: %w", pollId, err)
+	}
+	return nil
+}
+
+// lockPollForEditing locks the poll row until the end of the transaction and makes sure that it can still be edited.
+func lockPollForEditing(tx *sql.Tx, pollId int) error {
+	var id int
+	err := tx.QueryRow("SELECT `id` FROM "+TablePolls+" WHERE `id` = ? FOR UPDATE;", pollId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrPollNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var confirmed int
+	err = tx.QueryRow(`
+SELECT COUNT(*)
+FROM `+TableVotes+` V INNER JOIN `+TableOptions+` O ON V.option_id = O.id
+WHERE O.poll_id = ? AND V.confirmed_at IS NOT NULL;`, pollId).Scan(&confirmed)
+	if err != nil {
+		return err
+	}
+	if confirmed > 0 {
+		return ErrPollHasConfirmedVotes
+	}
+	return nil
+}
+
+func getPollOptionIds(tx *sql.Tx, pollId int) (map[int]bool, error) {
+	rows, err := tx.Query("SELECT `id` FROM "+TableOptions+" WHERE `poll_id` = ?;", pollId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+func insertPollOption(tx *sql.Tx, pollId int, position int, opt PollOption) error {
+	res, err := tx.Exec("INSERT INTO "+TableOptions+" (`poll_id`, `content`, `position`) VALUES (?, ?, ?);", pollId, opt.Content, position)
+	if err != nil {
+		return err
+	}
+	optionId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return insertOptionExtras(tx, int(optionId), opt.Extras)
+}
+
+func insertOptionExtras(tx *sql.Tx, optionId int, extras []OptionExtras) error {
+	for i, extra := range extras {
+		_, err := tx.Exec("INSERT INTO "+TableExtras+" (`option_id`, `type`, `content`, `position`) VALUES (?, ?, ?, ?);", optionId, extra.Type, extra.Value, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseExpiredPolls makes read-only all the polls whose closing time has passed. Returns the number of closed polls.
+func (m *MySQLPollsRepository) CloseExpiredPolls(now time.Time) (int64, error) {
+	res, err := m.Db.Exec("UPDATE "+TablePolls+" SET `is_readonly` = TRUE WHERE `is_readonly` = FALSE AND `closes_at` <= ?;", now)
+	if err != nil {
+		return 0, fmt.Errorf("CloseExpiredPolls: %v", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("CloseExpiredPolls: %v", err)
+	}
+	return affected, nil
 }
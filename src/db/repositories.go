@@ -1,8 +1,11 @@
 package db
 
+import "time"
+
 var UsersRepo MySQLUsersRepository
 var PollsRepo MySQLPollsRepository
 var VotesRepo MySQLVotesRepository
+var OutboxRepo MySQLOutboxRepository
 
 type UsersRepository interface {
 	GetUser(user User, createIfDoesNotExist bool) (*User, error)
@@ -12,13 +15,29 @@ type UsersRepository interface {
 
 type PollsRepository interface {
 	GetPoll(poll Poll, recursiveMode bool) (*Poll, error)
+	ListPolls(filter PollsFilter) ([]Poll, error)
 	GetPollOption(option PollOption, recursiveMode bool) (PollOption, error)
 	CreatePoll(poll Poll) (*Poll, error)
 	UpdatePoll(poll Poll) (*Poll, error)
+	DeletePoll(pollId int) error
+	CloseExpiredPolls(now time.Time) (int64, error)
 }
 
 type VotesRepository interface {
 	GetVote(vote PollVote) (*PollVote, error)
-	CreateVote(vote PollVote) (*PollVote, error)
+	CreateVote(vote PollVote, token string, confirmationEmail OutboxEmail) (*PollVote, error)
+	RotateConfirmationToken(voteId int, token string, confirmationEmail OutboxEmail) error
+	CreateWithdrawalToken(voteId int, token string, withdrawalEmail OutboxEmail) error
+	GetVoteSelections(voteId int) ([]VoteSelection, error)
 	UpdateVote(vote PollVote) (*PollVote, error)
+	DeleteVote(voteId int) error
+}
+
+type OutboxRepository interface {
+	Enqueue(email OutboxEmail) error
+	Claim(claimId string, limit int, claimTimeout int, maxAttempts int) ([]OutboxEmail, error)
+	MarkSent(id int) error
+	MarkFailed(id int, sendErr error, retryAfter int, dead bool) error
+	List(statuses []OutboxStatus, limit int) ([]OutboxEmail, error)
+	CountByStatus() (map[OutboxStatus]int, error)
 }
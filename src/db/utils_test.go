@@ -52,7 +52,7 @@ func TestObjectToSQLConditionAndPermitDefault(t *testing.T) {
 			Poll{
 				Options: []PollOption{},
 			},
-			"`id` = ? AND `title` = ? AND `description` = ? AND `create_time` = ?",
+			"`id` = ? AND `title` = ? AND `description` = ? AND `create_date` = ? AND `is_readonly` = ? AND `opens_at` = ? AND `closes_at` = ? AND `voting_mode` = ? AND `min_selections` = ? AND `max_selections` = ? AND `seats` = ? AND `score_min` = ? AND `score_max` = ?",
 		},
 	}
 
@@ -78,7 +78,7 @@ func TestObjectToSQLConditionOrPermitDefault(t *testing.T) {
 			Poll{
 				Options: []PollOption{},
 			},
-			"`id` = ? OR `title` = ? OR `description` = ? OR `create_time` = ?",
+			"`id` = ? OR `title` = ? OR `description` = ? OR `create_date` = ? OR `is_readonly` = ? OR `opens_at` = ? OR `closes_at` = ? OR `voting_mode` = ? OR `min_selections` = ? OR `max_selections` = ? OR `seats` = ? OR `score_min` = ? OR `score_max` = ?",
 		},
 	}
 
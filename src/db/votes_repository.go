@@ -19,9 +19,9 @@ func (m *MySQLVotesRepository) Init(Db *sql.DB) {
 
 func (m *MySQLVotesRepository) GetVote(vote PollVote) (*PollVote, error) {
 	condition, args := ObjectToSQLCondition(AND, vote, false)
-	row := Db.QueryRow("SELECT * FROM "+TableVotes+" WHERE "+condition+";", args...)
+	row := Db.QueryRow("SELECT id, user_id, option_id, confirmed_at, create_date, is_change FROM "+TableVotes+" WHERE "+condition+";", args...)
 	var resVote PollVote
-	if err := row.Scan(&resVote.Id, &resVote.UserId, &resVote.OptionId, &resVote.ConfirmedAt, &resVote.CreateDate); err != nil {
+	if err := row.Scan(&resVote.Id, &resVote.UserId, &resVote.OptionId, &resVote.ConfirmedAt, &resVote.CreateDate, &resVote.IsChange); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("GetVote: vote %v not found %v", vote, err)
 		}
@@ -30,22 +30,123 @@ func (m *MySQLVotesRepository) GetVote(vote PollVote) (*PollVote, error) {
 	return &resVote, nil
 }
 
-func (m *MySQLVotesRepository) CreateVote(vote PollVote) (*PollVote, error) {
-	res, err := Db.Exec("INSERT INTO "+TableVotes+"(user_id, option_id) VALUES (?, ?);", vote.UserId, vote.OptionId)
-	if err != nil {
-		return nil, fmt.Errorf("CreateVote %v: %v", vote, err)
+// CreateVote inserts the vote together with its selections, the confirmation token and the confirmation
+// email in a single transaction. If vote.Selections is empty, vote.OptionId is used as the only selection.
+func (m *MySQLVotesRepository) CreateVote(vote PollVote, token string, confirmationEmail OutboxEmail) (*PollVote, error) {
+	selections := vote.Selections
+	if len(selections) == 0 {
+		selections = []VoteSelection{{OptionId: vote.OptionId}}
 	}
 	var insertId int64
-	if insertId, err = res.LastInsertId(); err != nil {
-		return nil, fmt.Errorf("CreateVote %v - failed to get the id of the inserted row: %v", vote, err)
+	var pollId int
+	err := WithTransaction(Db, func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT poll_id FROM "+TableOptions+" WHERE id = ?;", selections[0].OptionId).Scan(&pollId)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("INSERT INTO "+TableVotes+"(user_id, option_id, is_change) VALUES (?, ?, ?);", vote.UserId, selections[0].OptionId, vote.IsChange)
+		if err != nil {
+			return err
+		}
+		if insertId, err = res.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get the id of the inserted row: %v", err)
+		}
+		for i, selection := range selections {
+			_, err = tx.Exec("INSERT INTO "+TableSelections+"(vote_id, option_id, position, score) VALUES (?, ?, ?, ?);", insertId, selection.OptionId, i, selection.Score)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("INSERT INTO "+TableConfirmations+"(token, vote_id, purpose) VALUES (?, ?, ?);", token, insertId, ConfirmationPurposeVote)
+		if err != nil {
+			return err
+		}
+		return enqueueEmail(tx, confirmationEmail)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateVote %v: %w", vote, err)
 	}
+	votesCreated.Inc(pollLabel(pollId))
 	insertedVote, err := m.GetVote(PollVote{Id: int(insertId)})
 	if err != nil {
 		return nil, fmt.Errorf("CreateVote %v - failed to get the inserted row: %v", vote, err)
 	}
+	insertedVote.Selections, err = m.GetVoteSelections(insertedVote.Id)
+	if err != nil {
+		return nil, fmt.Errorf("CreateVote %v - failed to get the inserted selections: %v", vote, err)
+	}
 	return insertedVote, err
 }
 
+// RotateConfirmationToken replaces the confirmation tokens of the unconfirmed vote with a new one and enqueues
+// the email carrying it in a single transaction.
+func (m *MySQLVotesRepository) RotateConfirmationToken(voteId int, token string, confirmationEmail OutboxEmail) error {
+	err := WithTransaction(Db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM "+TableConfirmations+" WHERE vote_id = ? AND purpose = ?;", voteId, ConfirmationPurposeVote)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO "+TableConfirmations+"(token, vote_id, purpose) VALUES (?, ?, ?);", token, voteId, ConfirmationPurposeVote)
+		if err != nil {
+			return err
+		}
+		return enqueueEmail(tx, confirmationEmail)
+	})
+	if err != nil {
+		return fmt.Errorf("RotateConfirmationToken %d: %w", voteId, err)
+	}
+	return nil
+}
+
+// CreateWithdrawalToken inserts the withdrawal token of the confirmed vote and enqueues the email carrying it in a
+// single transaction, so that no token is left behind without its email.
+func (m *MySQLVotesRepository) CreateWithdrawalToken(voteId int, token string, withdrawalEmail OutboxEmail) error {
+	err := WithTransaction(Db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO "+TableConfirmations+"(token, vote_id, purpose) VALUES (?, ?, ?);", token, voteId, ConfirmationPurposeWithdraw)
+		if err != nil {
+			return err
+		}
+		return enqueueEmail(tx, withdrawalEmail)
+	})
+	if err != nil {
+		return fmt.Errorf("CreateWithdrawalToken %d: %w", voteId, err)
+	}
+	return nil
+}
+
+// GetVoteSelections returns the options chosen on the ballot in their original order.
+func (m *MySQLVotesRepository) GetVoteSelections(voteId int) ([]VoteSelection, error) {
+	rows, err := Db.Query("SELECT * FROM "+TableSelections+" WHERE vote_id = ? ORDER BY position;", voteId)
+	if err != nil {
+		return nil, fmt.Errorf("GetVoteSelections %d: %v", voteId, err)
+	}
+	defer rows.Close()
+	selections := make([]VoteSelection, 0)
+	for rows.Next() {
+		var selection VoteSelection
+		if err = rows.Scan(&selection.Id, &selection.VoteId, &selection.OptionId, &selection.Position, &selection.Score); err != nil {
+			return nil, fmt.Errorf("GetVoteSelections %d: %v", voteId, err)
+		}
+		selections = append(selections, selection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetVoteSelections %d: %v", voteId, err)
+	}
+	return selections, nil
+}
+
+// DeleteVote removes the vote together with its selections and confirmation tokens.
+func (m *MySQLVotesRepository) DeleteVote(voteId int) error {
+	res, err := Db.Exec("DELETE FROM "+TableVotes+" WHERE id = ?;", voteId)
+	if err != nil {
+		return fmt.Errorf("DeleteVote %d: %v", voteId, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		return fmt.Errorf("DeleteVote %d: rows affected count other than 1 (err: %v)", voteId, err)
+	}
+	return nil
+}
+
 func (m *MySQLVotesRepository) UpdateVote(poll PollVote) (*PollVote, error) {
 	panic("implement me")
 }
@@ -0,0 +1,126 @@
+package email
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"switch-polls-backend/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SMTPMailer sends the messages through the configured SMTP server.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(conf *config.EmailConfiguration) *SMTPMailer {
+	m := &SMTPMailer{addr: conf.SmtpHost + ":" + strconv.Itoa(conf.SmtpPort)}
+	if conf.SenderMailboxUsername != "" {
+		m.auth = smtp.PlainAuth("", conf.SenderMailboxUsername, conf.SenderEmailPasswd, conf.SmtpHost)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(from string, to []string, msg []byte) error {
+	return smtp.SendMail(m.addr, m.auth, from, to, msg)
+}
+
+// Ping checks that the SMTP server accepts connections and greets the client.
+func (m *SMTPMailer) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(m.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileMailer writes every message as a separate .eml file into the 'new' directory of a maildir.
+type FileMailer struct {
+	dir     string
+	counter uint64
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			return nil, err
+		}
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(from string, to []string, msg []byte) error {
+	host, _ := os.Hostname()
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "." + strconv.Itoa(os.Getpid()) + "_" +
+		strconv.FormatUint(atomic.AddUint64(&m.counter, 1), 10) + "." + host + ".eml"
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, msg, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+// LogMailer only writes the messages to the log.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(from string, to []string, msg []byte) error {
+	log.Printf("Email from %s to %v:\n%s", from, to, msg)
+	return nil
+}
+
+type CapturedEmail struct {
+	From string
+	To   []string
+	Msg  []byte
+}
+
+// MemoryMailer keeps the messages in memory, so that tests can inspect them.
+type MemoryMailer struct {
+	mu     sync.Mutex
+	emails []CapturedEmail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(from string, to []string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emails = append(m.emails, CapturedEmail{From: from, To: append([]string(nil), to...), Msg: append([]byte(nil), msg...)})
+	return nil
+}
+
+// Emails returns a copy of the captured messages in the order they were sent.
+func (m *MemoryMailer) Emails() []CapturedEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]CapturedEmail(nil), m.emails...)
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emails = nil
+}
@@ -0,0 +1,224 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"switch-polls-backend/config"
+	"time"
+)
+
+// dkimSignedHeaders lists the header fields covered by the signature, if present in the message.
+var dkimSignedHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// DKIMSigner signs messages according to RFC 6376 using the relaxed/relaxed canonicalization.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// NewDKIMSigner loads the PEM encoded private key from keyPath. PKCS#1 and PKCS#8 RSA keys and PKCS#8 Ed25519 keys
+// are supported.
+func NewDKIMSigner(domain string, selector string, keyPath string) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("the DKIM domain and selector must be set")
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the DKIM key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", keyPath)
+	}
+	var key interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the DKIM key: %v", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &DKIMSigner{Domain: domain, Selector: selector, Key: k}, nil
+	case ed25519.PrivateKey:
+		return &DKIMSigner{Domain: domain, Selector: selector, Key: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+}
+
+// Sign returns the message with a DKIM-Signature header prepended.
+func (s *DKIMSigner) Sign(msg []byte, now time.Time) ([]byte, error) {
+	headers, body := splitMessage(msg)
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+
+	algorithm := "rsa-sha256"
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+	signed := selectHeaders(headers, dkimSignedHeaders)
+	names := make([]string, 0, len(signed))
+	for _, h := range signed {
+		names = append(names, strings.ToLower(headerName(h)))
+	}
+	sigHeader := "DKIM-Signature: v=1; a=" + algorithm + "; c=relaxed/relaxed; d=" + s.Domain + "; s=" + s.Selector +
+		"; t=" + strconv.FormatInt(now.Unix(), 10) + "; h=" + strings.Join(names, ":") +
+		"; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + "; b="
+
+	hash := sha256.New()
+	for _, h := range signed {
+		hash.Write([]byte(canonicalizeHeaderRelaxed(h)))
+	}
+	// The signature header itself is hashed with an empty b= tag and without the trailing CRLF.
+	hash.Write([]byte(strings.TrimSuffix(canonicalizeHeaderRelaxed(sigHeader), "\r\n")))
+	digest := hash.Sum(nil)
+
+	var sig []byte
+	var err error
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		// RFC 8463: Ed25519 signs the SHA-256 digest of the header data.
+		sig, err = s.Key.Sign(rand.Reader, digest, crypto.Hash(0))
+	} else {
+		sig, err = s.Key.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign the message: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigHeader + foldBase64(base64.StdEncoding.EncodeToString(sig)) + "\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// DKIMMailer signs the messages before passing them to the wrapped Mailer, so it works with every backend.
+type DKIMMailer struct {
+	Mailer Mailer
+	Signer *DKIMSigner
+}
+
+func (m *DKIMMailer) Send(from string, to []string, msg []byte) error {
+	signed, err := m.Signer.Sign(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return m.Mailer.Send(from, to, signed)
+}
+
+// withDKIM wraps the mailer in a DKIMMailer if signing is configured.
+func withDKIM(m Mailer, conf *config.EmailConfiguration) (Mailer, error) {
+	if conf.DkimPrivateKeyPath == "" {
+		return m, nil
+	}
+	signer, err := NewDKIMSigner(conf.DkimDomain, conf.DkimSelector, conf.DkimPrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &DKIMMailer{Mailer: m, Signer: signer}, nil
+}
+
+// splitMessage returns the header fields (with their continuation lines and the trailing CRLF) and the body.
+func splitMessage(msg []byte) ([]string, []byte) {
+	var headerPart string
+	var body []byte
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		headerPart, body = string(msg[:i+2]), msg[i+4:]
+	} else {
+		headerPart = string(msg)
+	}
+	headers := make([]string, 0)
+	for _, line := range strings.SplitAfter(headerPart, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1] += line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, body
+}
+
+// selectHeaders picks the header fields to sign. As in RFC 6376 section 5.4.2, the last instance of each field wins.
+func selectHeaders(headers []string, names []string) []string {
+	selected := make([]string, 0, len(names))
+	for _, name := range names {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if strings.EqualFold(headerName(headers[i]), name) {
+				selected = append(selected, headers[i])
+				break
+			}
+		}
+	}
+	return selected
+}
+
+func headerName(header string) string {
+	if i := strings.IndexByte(header, ':'); i >= 0 {
+		return strings.TrimRight(header[:i], " \t")
+	}
+	return header
+}
+
+// canonicalizeHeaderRelaxed implements the relaxed header canonicalization (RFC 6376 section 3.4.2).
+func canonicalizeHeaderRelaxed(header string) string {
+	i := strings.IndexByte(header, ':')
+	if i < 0 {
+		return header
+	}
+	name := strings.ToLower(strings.TrimRight(header[:i], " \t"))
+	value := strings.ReplaceAll(header[i+1:], "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return name + ":" + value + "\r\n"
+}
+
+// canonicalizeBodyRelaxed implements the relaxed body canonicalization (RFC 6376 section 3.4.4).
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		// Collapse the inner whitespace to a single space and drop the trailing one.
+		collapsed := strings.Join(strings.FieldsFunc(line, isWSP), " ")
+		if len(line) > 0 && isWSP(rune(line[0])) && collapsed != "" {
+			collapsed = " " + collapsed
+		}
+		lines[i] = collapsed
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldBase64 splits the signature into lines short enough for SMTP. The whitespace is ignored by verifiers.
+func foldBase64(sig string) string {
+	const width = 72
+	var b strings.Builder
+	for len(sig) > width {
+		b.WriteString(sig[:width] + "\r\n\t")
+		sig = sig[width:]
+	}
+	b.WriteString(sig)
+	return b.String()
+}
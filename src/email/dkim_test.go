@@ -0,0 +1,152 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalizationRelaxed(t *testing.T) {
+	// The example from RFC 6376 section 3.4.5.
+	headers, body := splitMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	var canonical string
+	for _, h := range headers {
+		canonical += canonicalizeHeaderRelaxed(h)
+	}
+	if canonical != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("unexpected canonical headers %q", canonical)
+	}
+	if got := string(canonicalizeBodyRelaxed(body)); got != " C\r\nD E\r\n" {
+		t.Errorf("unexpected canonical body %q", got)
+	}
+	if got := canonicalizeBodyRelaxed([]byte("\r\n\r\n")); len(got) != 0 {
+		t.Errorf("expected an empty canonical body, got %q", got)
+	}
+}
+
+func TestDKIMSignRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeKey(t, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	testDKIMSignature(t, path, func(digest []byte, sig []byte) error {
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, sig)
+	})
+}
+
+func TestDKIMSignEd25519(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeKey(t, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	testDKIMSignature(t, path, func(digest []byte, sig []byte) error {
+		if !ed25519.Verify(pub, digest, sig) {
+			return os.ErrInvalid
+		}
+		return nil
+	})
+}
+
+func TestDKIMMailerWrapsBackend(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	conf := testConfig
+	conf.Mailer = MailerMemory
+	conf.DkimDomain = "example.com"
+	conf.DkimSelector = "polls"
+	conf.DkimPrivateKeyPath = writeKey(t, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	m, err := NewMailer(&conf)
+	if err != nil {
+		t.Fatalf("NewMailer failed: %v", err)
+	}
+	if err = Send(m, &conf, Message{To: "user@example.com", Subject: "Test", Text: "body"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	emails := m.(*DKIMMailer).Mailer.(*MemoryMailer).Emails()
+	if len(emails) != 1 || !bytes.HasPrefix(emails[0].Msg, []byte("DKIM-Signature: ")) {
+		t.Errorf("expected a signed message to be captured, got %v", emails)
+	}
+}
+
+func writeKey(t *testing.T, block *pem.Block) string {
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testDKIMSignature signs a composed message and verifies it the way a receiving server would.
+func testDKIMSignature(t *testing.T, keyPath string, verify func(digest []byte, sig []byte) error) {
+	signer, err := NewDKIMSigner("example.com", "polls", keyPath)
+	if err != nil {
+		t.Fatalf("NewDKIMSigner failed: %v", err)
+	}
+	msg, err := Compose(&testConfig, Message{To: "user@example.com", Subject: "Potwierdź swój głos", Text: "text", HTML: "<p>html</p>"}, time.Now())
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	signed, err := signer.Sign(msg, time.Now())
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	if err = verifyDKIM(signed, verify); err != nil {
+		t.Errorf("the signature does not verify: %v", err)
+	}
+	tampered := bytes.Replace(signed, []byte("<p>html</p>"), []byte("<p>evil</p>"), 1)
+	if err = verifyDKIM(tampered, verify); err == nil {
+		t.Errorf("the signature verifies for a tampered body")
+	}
+	tampered = bytes.Replace(signed, []byte("To: <user@example.com>"), []byte("To: <other@example.com>"), 1)
+	if err = verifyDKIM(tampered, verify); err == nil {
+		t.Errorf("the signature verifies for a tampered header")
+	}
+}
+
+func verifyDKIM(msg []byte, verify func(digest []byte, sig []byte) error) error {
+	headers, body := splitMessage(msg)
+	sigHeader := headers[0]
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(strings.SplitN(sigHeader, ":", 2)[1], ";") {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[strings.TrimSpace(kv[0])] = strings.Join(strings.Fields(kv[1]), "")
+		}
+	}
+	if tags["c"] != "relaxed/relaxed" || tags["d"] != "example.com" || tags["s"] != "polls" {
+		return os.ErrInvalid
+	}
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return os.ErrInvalid
+	}
+
+	hash := sha256.New()
+	for _, h := range selectHeaders(headers[1:], strings.Split(tags["h"], ":")) {
+		hash.Write([]byte(canonicalizeHeaderRelaxed(h)))
+	}
+	unsigned := sigHeader[:strings.Index(sigHeader, "; b=")+4]
+	hash.Write([]byte(strings.TrimSuffix(canonicalizeHeaderRelaxed(unsigned), "\r\n")))
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	return verify(hash.Sum(nil), sig)
+}
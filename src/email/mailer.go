@@ -0,0 +1,71 @@
+// Package email delivers the emails composed by the service. The backend is chosen with EmailConfiguration.Mailer.
+package email
+
+import (
+	"fmt"
+	"log"
+	"switch-polls-backend/config"
+	"switch-polls-backend/metrics"
+	"time"
+)
+
+const (
+	MailerSMTP   = "smtp"
+	MailerFile   = "file"
+	MailerLog    = "log"
+	MailerMemory = "memory"
+)
+
+// Mailer delivers an already composed message to the given recipients.
+type Mailer interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+var DefaultMailer Mailer
+
+var emailsSent = metrics.NewCounterVec("spolls_emails_sent_total", "Emails handed to the mailer, by result.", "result")
+
+func InitMailer() {
+	var err error
+	DefaultMailer, err = NewMailer(&config.Cfg.EmailConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialise the mailer: %v", err)
+	}
+	log.Printf("Using the %s mailer.", config.Cfg.EmailConfig.Mailer)
+}
+
+// NewMailer creates the backend selected in the configuration. The messages are DKIM signed if a key is configured.
+func NewMailer(conf *config.EmailConfiguration) (Mailer, error) {
+	var m Mailer
+	var err error
+	switch conf.Mailer {
+	case MailerSMTP, "":
+		m = NewSMTPMailer(conf)
+	case MailerFile:
+		m, err = NewFileMailer(conf.MailerDirectory)
+	case MailerLog:
+		m = NewLogMailer()
+	case MailerMemory:
+		m = NewMemoryMailer()
+	default:
+		return nil, fmt.Errorf("unknown mailer '%s'", conf.Mailer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return withDKIM(m, conf)
+}
+
+// Send composes the message and delivers it to its receiver from the configured sender address.
+func Send(m Mailer, conf *config.EmailConfiguration, msg Message) error {
+	raw, err := Compose(conf, msg, time.Now())
+	if err == nil {
+		err = m.Send(conf.SenderEmail, []string{msg.To}, raw)
+	}
+	if err != nil {
+		emailsSent.Inc("failure")
+		return err
+	}
+	emailsSent.Inc("success")
+	return nil
+}
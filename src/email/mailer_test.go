@@ -0,0 +1,109 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"switch-polls-backend/config"
+	"testing"
+	"time"
+)
+
+var testConfig = config.EmailConfiguration{
+	SenderEmail: "switch@example.com",
+}
+
+func TestMemoryMailerCapturesEmails(t *testing.T) {
+	m := NewMemoryMailer()
+	if err := Send(m, &testConfig, Message{To: "user@example.com", Subject: "Subject", HTML: "<p>body</p>"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	emails := m.Emails()
+	if len(emails) != 1 {
+		t.Fatalf("expected 1 captured email, got %d", len(emails))
+	}
+	if emails[0].From != "switch@example.com" || len(emails[0].To) != 1 || emails[0].To[0] != "user@example.com" {
+		t.Errorf("unexpected envelope %v -> %v", emails[0].From, emails[0].To)
+	}
+	if !bytes.Contains(emails[0].Msg, []byte("<p>body</p>")) {
+		t.Errorf("the body is missing from the message: %s", emails[0].Msg)
+	}
+	m.Reset()
+	if len(m.Emails()) != 0 {
+		t.Errorf("expected no emails after reset")
+	}
+}
+
+func TestFileMailerWritesMaildir(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = m.Send("a@example.com", []string{"b@example.com"}, []byte("Subject: test\r\n\r\nbody")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files in new/, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if string(data) != "Subject: test\r\n\r\nbody" {
+		t.Errorf("unexpected file contents %q", data)
+	}
+}
+
+func TestNewMailerRejectsUnknownBackend(t *testing.T) {
+	if _, err := NewMailer(&config.EmailConfiguration{Mailer: "carrier-pigeon"}); err == nil {
+		t.Errorf("expected an error for an unknown mailer")
+	}
+}
+
+func TestSMTPMailerPing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		rd := bufio.NewReader(conn)
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "QUIT") {
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			}
+			conn.Write([]byte("250 localhost\r\n"))
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	m := NewSMTPMailer(&config.EmailConfiguration{SmtpHost: "127.0.0.1", SmtpPort: port})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Ping(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ln.Close()
+	m = NewSMTPMailer(&config.EmailConfiguration{SmtpHost: "127.0.0.1", SmtpPort: port})
+	if err := m.Ping(ctx); err == nil {
+		t.Fatalf("expected an error for the closed port " + strconv.Itoa(port))
+	}
+}
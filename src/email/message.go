@@ -0,0 +1,92 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"switch-polls-backend/config"
+	"switch-polls-backend/utils"
+	"time"
+)
+
+// Message is a single email with a plain-text and an HTML alternative. Either body may be empty.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Compose builds the RFC 5322 message sent by the service. The subject is RFC 2047 encoded and the bodies are
+// sent as a multipart/alternative entity, the plain-text part first as the least preferred one.
+func Compose(conf *config.EmailConfiguration, msg Message, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", (&mail.Address{Address: conf.SenderEmail}).String())
+	writeHeader(&buf, "To", (&mail.Address{Address: msg.To}).String())
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader(&buf, "Date", now.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", newMessageId(conf.SenderEmail))
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if msg.Text == "" || msg.HTML == "" {
+		contentType, body := "text/plain", msg.Text
+		if msg.HTML != "" {
+			contentType, body = "text/html", msg.HTML
+		}
+		writeHeader(&buf, "Content-Type", contentType+"; charset=utf-8")
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return nil, fmt.Errorf("compose email to %s: %v", msg.To, err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{{"text/plain", msg.Text}, {"text/html", msg.HTML}} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("compose email to %s: %v", msg.To, err)
+		}
+		if err = writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, fmt.Errorf("compose email to %s: %v", msg.To, err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("compose email to %s: %v", msg.To, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, key string, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+// writeQuotedPrintable encodes the body. The encoder turns every line break into CRLF.
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// newMessageId returns a unique Message-ID in the domain of the sender address.
+func newMessageId(sender string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(sender, "@"); at >= 0 && at < len(sender)-1 {
+		domain = sender[at+1:]
+	}
+	return "<" + utils.GetNewToken() + "@" + domain + ">"
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComposeMultipartAlternative(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	raw, err := Compose(&testConfig, Message{
+		To:      "jan.kowalski@example.com",
+		Subject: "[SWITCH POLLS] Potwierdź swój głos",
+		Text:    "Zażółć gęślą jaźń\nhttps://example.com/confirm",
+		HTML:    "<p>Zażółć gęślą jaźń</p>",
+	}, now)
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	if bytes.Contains(bytes.ReplaceAll(raw, []byte("\r\n"), nil), []byte("\n")) {
+		t.Errorf("the message contains bare LF line endings")
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("cannot parse the message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "[SWITCH POLLS] Potwierdź swój głos" {
+		t.Errorf("unexpected subject %q (err: %v)", subject, err)
+	}
+	if date, err := msg.Header.Date(); err != nil || !date.Equal(now) {
+		t.Errorf("unexpected date %v (err: %v)", date, err)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("unexpected Message-ID %q", id)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q (err: %v)", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	expected := []struct{ contentType, body string }{
+		{"text/plain", "Zażółć gęślą jaźń\r\nhttps://example.com/confirm"},
+		{"text/html", "<p>Zażółć gęślą jaźń</p>"},
+	}
+	for _, exp := range expected {
+		part, err := mr.NextRawPart()
+		if err != nil {
+			t.Fatalf("missing the %s part: %v", exp.contentType, err)
+		}
+		if ct, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); ct != exp.contentType {
+			t.Errorf("expected a %s part, got %s", exp.contentType, ct)
+		}
+		body, _ := io.ReadAll(quotedprintable.NewReader(part))
+		if string(body) != exp.body {
+			t.Errorf("unexpected %s body %q", exp.contentType, body)
+		}
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got error %v", err)
+	}
+}
+
+func TestComposeSinglePart(t *testing.T) {
+	raw, err := Compose(&testConfig, Message{To: "user@example.com", Subject: "Test", HTML: "<p>body</p>"}, time.Now())
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("cannot parse the message: %v", err)
+	}
+	if ct, _, _ := mime.ParseMediaType(msg.Header.Get("Content-Type")); ct != "text/html" {
+		t.Errorf("expected a text/html message, got %s", ct)
+	}
+}
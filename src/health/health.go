@@ -0,0 +1,105 @@
+// Package health reports whether the service is alive and ready to serve the requests.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOk   = "ok"
+	StatusFail = "fail"
+)
+
+// Check verifies a single dependency of the service.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Run runs the checks concurrently, each of them limited by the timeout. The report fails if any check fails.
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	report := Report{Status: StatusOk, Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := runCheck(checkCtx, c)
+			res := CheckResult{Status: StatusOk, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Status = StatusFail
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.Name] = res
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+// runCheck returns once the check finishes or its context is done, whichever comes first.
+func runCheck(ctx context.Context, c Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LivenessHandler reports that the process is up. It does not check any dependency.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, Report{Status: StatusOk})
+}
+
+// ReadinessHandler runs the checks on every request and responds with 503 Service Unavailable if any of them fails.
+func ReadinessHandler(timeout time.Duration, checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), timeout, checks)
+		if report.Status != StatusOk {
+			log.Printf("Readiness check failed: %+v", report.Checks)
+		}
+		writeReport(w, report)
+	}
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("writeReport %v: %v", report, err)
+	}
+}
@@ -0,0 +1,99 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunAllOk(t *testing.T) {
+	report := Run(context.Background(), time.Second, []Check{
+		{Name: "a", Run: func(ctx context.Context) error { return nil }},
+		{Name: "b", Run: func(ctx context.Context) error { return nil }},
+	})
+	if report.Status != StatusOk {
+		t.Fatalf("expected %s, got %s", StatusOk, report.Status)
+	}
+	if len(report.Checks) != 2 || report.Checks["a"].Status != StatusOk || report.Checks["b"].Status != StatusOk {
+		t.Fatalf("unexpected checks: %+v", report.Checks)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	report := Run(context.Background(), time.Second, []Check{
+		{Name: "ok", Run: func(ctx context.Context) error { return nil }},
+		{Name: "broken", Run: func(ctx context.Context) error { return errors.New("boom") }},
+	})
+	if report.Status != StatusFail {
+		t.Fatalf("expected %s, got %s", StatusFail, report.Status)
+	}
+	if res := report.Checks["broken"]; res.Status != StatusFail || res.Error != "boom" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if report.Checks["ok"].Status != StatusOk {
+		t.Fatalf("the passing check was reported as %s", report.Checks["ok"].Status)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	start := time.Now()
+	report := Run(context.Background(), 20*time.Millisecond, []Check{
+		{Name: "stuck", Run: func(ctx context.Context) error {
+			<-block
+			return nil
+		}},
+	})
+	if time.Since(start) > time.Second {
+		t.Fatalf("the check was not abandoned after the timeout")
+	}
+	if res := report.Checks["stuck"]; res.Status != StatusFail || res.Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	fail := false
+	handler := ReadinessHandler(time.Second, Check{Name: "dep", Run: func(ctx context.Context) error {
+		if fail {
+			return errors.New("down")
+		}
+		return nil
+	}})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	fail = true
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != StatusFail || report.Checks["dep"].Error != "down" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	LivenessHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+}
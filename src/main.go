@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"switch-polls-backend/admin"
+	"switch-polls-backend/captcha"
 	"switch-polls-backend/config"
 	"switch-polls-backend/db"
+	"switch-polls-backend/email"
+	"switch-polls-backend/health"
+	"switch-polls-backend/metrics"
 	"switch-polls-backend/polls"
+	"switch-polls-backend/ratelimit"
 	"switch-polls-backend/utils"
+	"switch-polls-backend/workers"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -18,26 +31,154 @@ func main() {
 	}
 	db.ApplyMigrations()
 	db.InitDb()
+	email.InitMailer()
+	captcha.InitVerifier()
+	limiter := ratelimit.NewLimiter()
+
+	// background workers, stopped once the http server shuts down
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workersWg sync.WaitGroup
+	startWorker := func(run func(ctx context.Context)) {
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			run(workersCtx)
+		}()
+	}
+	startWorker(workers.RunPollScheduler)
+	startWorker(workers.RunCleanup)
+	startWorker(func(ctx context.Context) { workers.RunOutbox(ctx, email.DefaultMailer) })
+	startWorker(func(ctx context.Context) { workers.RunRateLimitEviction(ctx, limiter) })
+
 	// routing
+	trustedProxies, err := utils.ParseTrustedProxies(config.Cfg.WebConfig.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	limits := config.Cfg.WebConfig.EndpointsLimits
 	r := mux.NewRouter()
-	r.Use(contentTypeJsonMiddleware, loggingMiddleware)
+	r.Use(clientIpMiddleware(trustedProxies), metricsMiddleware, contentTypeJsonMiddleware, loggingMiddleware, rateLimitMiddleware(limiter, map[string]rateLimitedRoute{
+		"polls_list":              {Limits: limits.Polls.ListEndpoint},
+		"poll_get":                {Limits: limits.Polls.PollEndpoint},
+		"poll_results_get":        {Limits: limits.Polls.ResultsEndpoint},
+		"poll_vote":               {Limits: limits.Polls.VotesEndpoint, ByUsername: true},
+		"poll_vote_change":        {Limits: limits.Polls.VotesEndpoint, ByUsername: true},
+		"poll_vote_resend":        {Limits: limits.Polls.ResendEndpoint, ByUsername: true},
+		"poll_vote_withdraw":      {Limits: limits.Polls.WithdrawEndpoint, ByUsername: true},
+		"poll_confirm_vote":       {Limits: limits.Polls.ConfirmVoteEndpoint},
+		"poll_confirm_withdrawal": {Limits: limits.Polls.ConfirmWithdrawalEndpoint},
+		"poll_opt_out":            {Limits: limits.Polls.OptOutEndpoint},
+		"pow_challenge":           {Limits: limits.Polls.ChallengeEndpoint},
+		"admin_polls":             {Limits: limits.Admin.PollsEndpoint},
+		"admin_polls_update":      {Limits: limits.Admin.PollsEndpoint},
+		"admin_polls_delete":      {Limits: limits.Admin.PollsEndpoint},
+		"admin_outbox":            {Limits: limits.Admin.OutboxEndpoint},
+	}))
 
 	// subrouters
 	apiRouter := r.PathPrefix(config.Cfg.WebConfig.ApiPrefix).Subrouter()
 	pollsRoot := apiRouter.PathPrefix("/polls").Subrouter()
 	pollsRoot.Use(corsTerminateMiddleware)
 
+	// proof-of-work challenges
+	if pow, ok := captcha.DefaultVerifier.(*captcha.ProofOfWorkVerifier); ok {
+		apiRouter.Handle("/challenge", corsTerminateMiddleware(http.HandlerFunc(pow.ChallengeHandler))).Methods(http.MethodGet, http.MethodOptions).Name("pow_challenge")
+	}
+
 	// polls
-	pollsRoot.HandleFunc("/confirm_vote/{token:[A-Za-z0-9\\-]+}", polls.PollConfirmHandler).Methods(http.MethodGet)
+	pollsRoot.HandleFunc("/confirm_vote/{token:[A-Za-z0-9\\-]+}", polls.PollConfirmHandler).Methods(http.MethodGet).Name("poll_confirm_vote")
+	pollsRoot.HandleFunc("/confirm_withdrawal/{token:[A-Za-z0-9\\-]+}", polls.PollConfirmWithdrawalHandler).Methods(http.MethodGet).Name("poll_confirm_withdrawal")
+	pollsRoot.HandleFunc("/opt_out/{token:[A-Za-z0-9_\\-]+\\.[A-Za-z0-9_\\-]+}", polls.PollOptOutHandler).Methods(http.MethodGet).Name("poll_opt_out")
 
-	pollsRecaptcha := pollsRoot.PathPrefix("").Subrouter()
-	pollsRecaptcha.Use(recaptchaMiddleware)
-	pollsRecaptcha.HandleFunc("/{id:[0-9]+}", polls.PollHandler).Methods(http.MethodGet, http.MethodOptions)
-	pollsRecaptcha.HandleFunc("/{id:[0-9]+}/results", polls.PollResultsHandler).Methods(http.MethodGet, http.MethodOptions)
-	pollsRecaptcha.HandleFunc("/vote", polls.PollVoteHandler).Methods(http.MethodPost, http.MethodOptions)
+	pollsCaptcha := pollsRoot.PathPrefix("").Subrouter()
+	pollsCaptcha.Use(captchaMiddleware)
+	pollsCaptcha.HandleFunc("", polls.PollsListHandler).Methods(http.MethodGet, http.MethodOptions).Name("polls_list")
+	pollsCaptcha.HandleFunc("/{id:[0-9]+}", polls.PollHandler).Methods(http.MethodGet, http.MethodOptions).Name("poll_get")
+	pollsCaptcha.HandleFunc("/{id:[0-9]+}/results", polls.PollResultsHandler).Methods(http.MethodGet, http.MethodOptions).Name("poll_results_get")
+	pollsCaptcha.HandleFunc("/vote", polls.PollVoteHandler).Methods(http.MethodPost, http.MethodOptions).Name("poll_vote")
+	pollsCaptcha.HandleFunc("/vote/resend", polls.PollResendHandler).Methods(http.MethodPost, http.MethodOptions).Name("poll_vote_resend")
+	pollsCaptcha.HandleFunc("/vote/change", polls.PollVoteChangeHandler).Methods(http.MethodPost, http.MethodOptions).Name("poll_vote_change")
+	pollsCaptcha.HandleFunc("/vote/withdraw", polls.PollWithdrawHandler).Methods(http.MethodPost, http.MethodOptions).Name("poll_vote_withdraw")
+
+	// admin
+	adminRoot := apiRouter.PathPrefix("/admin").Subrouter()
+	adminRoot.Use(adminAuthMiddleware)
+	adminRoot.HandleFunc("/polls", admin.CreatePollHandler).Methods(http.MethodPost).Name("admin_polls")
+	adminRoot.HandleFunc("/polls/{id:[0-9]+}", admin.UpdatePollHandler).Methods(http.MethodPut).Name("admin_polls_update")
+	adminRoot.HandleFunc("/polls/{id:[0-9]+}", admin.DeletePollHandler).Methods(http.MethodDelete).Name("admin_polls_delete")
+	adminRoot.HandleFunc("/outbox", admin.OutboxHandler).Methods(http.MethodGet).Name("admin_outbox")
+
+	// health
+	readinessChecks := []health.Check{
+		{Name: "database", Run: db.Ping},
+		{Name: "migrations", Run: db.CheckMigrations},
+	}
+	if mailer := config.Cfg.EmailConfig.Mailer; config.Cfg.WebConfig.HealthCheckSmtp && (mailer == email.MailerSMTP || mailer == "") {
+		readinessChecks = append(readinessChecks, health.Check{Name: "smtp", Run: email.NewSMTPMailer(&config.Cfg.EmailConfig).Ping})
+	}
+	healthCheckTimeout := seconds(config.Cfg.WebConfig.HealthCheckTimeout)
+	r.HandleFunc("/healthz", health.LivenessHandler).Methods(http.MethodGet).Name("healthz")
+	r.Handle("/readyz", health.ReadinessHandler(healthCheckTimeout, readinessChecks...)).Methods(http.MethodGet).Name("readyz")
+
+	// metrics
+	if path := config.Cfg.WebConfig.MetricsPath; path != "" {
+		var handler http.Handler = metrics.Handler()
+		if config.Cfg.WebConfig.MetricsRequireAdminKey {
+			handler = adminAuthMiddleware(handler)
+		}
+		r.Handle(path, handler).Methods(http.MethodGet).Name("metrics")
+	}
 
 	// start http
-	http.Handle("/", r)
-	log.Printf("Listening on %s://%s%s\n", config.Cfg.WebConfig.Protocol, utils.GetListeningAddress(), config.Cfg.WebConfig.ApiPrefix)
-	log.Fatal(http.ListenAndServe(utils.GetListeningAddress(), nil))
+	timeouts := config.Cfg.WebConfig.Timeouts
+	server := &http.Server{
+		Addr:              utils.GetListeningAddress(),
+		Handler:           r,
+		ReadTimeout:       seconds(timeouts.Read),
+		ReadHeaderTimeout: seconds(timeouts.ReadHeader),
+		WriteTimeout:      seconds(timeouts.Write),
+		IdleTimeout:       seconds(timeouts.Idle),
+	}
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	go func() {
+		log.Printf("Listening on %s://%s%s\n", config.Cfg.WebConfig.Protocol, server.Addr, config.Cfg.WebConfig.ApiPrefix)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-signalCtx.Done()
+	stopSignals()
+	log.Println("Shutting down, draining the in-flight requests...")
+	shutdownCtx := context.Background()
+	if timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, seconds(timeouts.Shutdown))
+		defer cancel()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("The http server did not shut down cleanly: %v", err)
+	}
+
+	log.Println("Stopping the background workers...")
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		workersWg.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		log.Println("The background workers did not stop in time.")
+	}
+
+	db.CloseMigrations()
+	db.CloseDb()
+	log.Println("---- Switch polls backend has stopped. ----")
+}
+
+func seconds(s int) time.Duration {
+	return time.Duration(s) * time.Second
 }
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("http_requests_total", "Handled requests.", "route", "code")
+	requests.Inc("poll_get", "200")
+	requests.Inc("poll_get", "200")
+	requests.Add(3, `we"ird\route`, "500")
+	latency := r.NewHistogramVec("http_request_duration_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "poll_get")
+	latency.Observe(0.5, "poll_get")
+	r.NewGaugeFunc("db_open_connections", "Open connections.", func() float64 { return 4 })
+
+	var buf bytes.Buffer
+	if err := r.WriteText(bufio.NewWriter(&buf)); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	expected := `# HELP db_open_connections Open connections.
+# TYPE db_open_connections gauge
+db_open_connections 4
+# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="poll_get",le="0.1"} 1
+http_request_duration_seconds_bucket{route="poll_get",le="1"} 2
+http_request_duration_seconds_bucket{route="poll_get",le="+Inf"} 2
+http_request_duration_seconds_sum{route="poll_get"} 0.55
+http_request_duration_seconds_count{route="poll_get"} 2
+# HELP http_requests_total Handled requests.
+# TYPE http_requests_total counter
+http_requests_total{route="poll_get",code="200"} 2
+http_requests_total{route="we\"ird\\route",code="500"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("votes_total", "Votes.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for a duplicate metric")
+		}
+	}()
+	r.NewCounterVec("votes_total", "Votes.")
+}
+
+func TestHandlerContentType(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("votes_total", "Votes.", "poll").Inc("1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") || !strings.Contains(w.Body.String(), `votes_total{poll="1"} 1`) {
+		t.Errorf("unexpected response %q: %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
@@ -1,22 +1,98 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"switch-polls-backend/captcha"
 	"switch-polls-backend/config"
+	"switch-polls-backend/metrics"
+	"switch-polls-backend/ratelimit"
 	"switch-polls-backend/utils"
+	"time"
 )
 
+// clientIpMiddleware stores the client address, as seen through the trusted proxies, in the request context.
+func clientIpMiddleware(proxies utils.TrustedProxies) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(utils.WithClientIp(r.Context(), proxies.ClientIp(r))))
+		})
+	}
+}
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total", "HTTP requests, by route, method and status code.",
+		"route", "method", "code")
+	httpRequestDuration = metrics.NewHistogramVec("http_request_duration_seconds", "HTTP request latency, by route and method.",
+		metrics.DefaultBuckets, "route", "method")
+	captchaRejections = metrics.NewCounterVec("spolls_captcha_rejections_total", "Requests rejected by the captcha middleware, by route and reason.",
+		"route", "reason")
+)
+
+// statusRecorder remembers the status code written by the handlers.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// metricsMiddleware counts the requests and measures their latency per route. The routes are labelled with their
+// names, or the path templates if unnamed, to keep the number of series bounded.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := currentRouteName(r)
+		if route == "" {
+			if cur := mux.CurrentRoute(r); cur != nil {
+				route, _ = cur.GetPathTemplate()
+			}
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Request to %s from %s (method: %s).", r.URL, r.RemoteAddr, r.Method)
+		log.Printf("Request to %s from %s (method: %s).", r.URL, utils.GetClientIp(r), r.Method)
 		next.ServeHTTP(w, r)
 	})
 }
 
 func corsTerminateMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", config.Cfg.WebConfig.CORS.AccessControlAllowOrigin)
+		allowOrigin, varies := config.Cfg.WebConfig.CORS.AllowOrigin(r.Header.Get("Origin"))
+		if varies {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		}
 		w.Header().Set("Access-Control-Allow-Headers", config.Cfg.WebConfig.CORS.AccessControlAllowHeaders)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -33,14 +109,154 @@ func contentTypeJsonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func recaptchaMiddleware(next http.Handler) http.Handler {
+// captchaMiddleware verifies the captcha token against the requirements configured for the matched route
+// and stores the result in the request context.
+func captchaMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		if utils.VerifyRecaptcha(&ctx, r) {
-			next.ServeHTTP(w, r.Clone(ctx))
-		} else {
+		routeName := currentRouteName(r)
+		routeCfg, ok := config.Cfg.WebConfig.CaptchaRoutes[routeName]
+		if !ok {
+			log.Printf("No captcha configuration for route '%s' (%s)", routeName, r.URL)
+			captchaRejections.Inc(routeName, "unconfigured")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		minScore := routeCfg.MinScore
+		if minScore <= 0 {
+			minScore = config.Cfg.WebConfig.RecaptchaMinScore
+		}
+
+		res, err := captcha.VerifyRequest(captcha.DefaultVerifier, r, utils.GetClientIp(r), routeCfg.Action, minScore)
+		if err != nil {
+			log.Printf("Request to %s from %s failed captcha verification: %v", routeName, utils.GetClientIp(r), err)
+			captchaRejections.Inc(routeName, captchaRejectionReason(err))
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Invalid recaptcha token"))
+			w.Write([]byte("Invalid captcha token"))
+			return
 		}
+		next.ServeHTTP(w, r.WithContext(captcha.NewContext(r.Context(), res)))
+	})
+}
+
+func captchaRejectionReason(err error) string {
+	switch {
+	case errors.Is(err, captcha.ErrMissingToken):
+		return "missing_token"
+	case errors.Is(err, captcha.ErrRejected):
+		return "rejected"
+	case errors.Is(err, captcha.ErrLowScore):
+		return "low_score"
+	case errors.Is(err, captcha.ErrWrongAction):
+		return "wrong_action"
+	case errors.Is(err, captcha.ErrUnavailable):
+		return "unavailable"
+	}
+	return "other"
+}
+
+// rateLimitedRoute binds the limits to a named route.
+type rateLimitedRoute struct {
+	Limits config.Limits
+	// Whether the request body carries the username UserRateLimit applies to
+	ByUsername bool
+}
+
+// rateLimitMiddleware limits the requests to the named routes per client IP and, where applicable, per username.
+// The state of the most restrictive bucket is reported in the RateLimit-* headers. A request denied by one bucket
+// takes no token from the other.
+func rateLimitMiddleware(limiter *ratelimit.Limiter, routes map[string]rateLimitedRoute) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := currentRouteName(r)
+			route, ok := routes[name]
+			if !ok || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var limits []ratelimit.Limit
+			if rate, ok := toRate(route.Limits.IpRateLimit); ok {
+				limits = append(limits, ratelimit.Limit{Key: name + "|ip|" + utils.GetClientIp(r), Rate: rate})
+			}
+			if rate, ok := toRate(route.Limits.UserRateLimit); ok && route.ByUsername {
+				if username := peekUsername(r, route.Limits.MaxBodySize); username != "" {
+					limits = append(limits, ratelimit.Limit{Key: name + "|user|" + username, Rate: rate})
+				}
+			}
+			if len(limits) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// the tokens are taken only if all the buckets allow the request
+			var status *ratelimit.Status
+			for _, s := range limiter.AllowAll(limits, time.Now()) {
+				s := s
+				if status == nil || s.Remaining < status.Remaining || (s.Remaining == status.Remaining && s.Reset > status.Reset) {
+					status = &s
+				}
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(status.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(status.Reset))
+			if !status.Allowed {
+				log.Printf("Rate limited a request to %s from %s", name, utils.GetClientIp(r))
+				w.Header().Set("Retry-After", strconv.Itoa(status.Reset))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte("Too many requests"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func toRate(limit config.RateLimit) (ratelimit.Rate, bool) {
+	if limit.PerMinute <= 0 || limit.Burst <= 0 {
+		return ratelimit.Rate{}, false
+	}
+	return ratelimit.Rate{PerSecond: limit.PerMinute / 60, Burst: limit.Burst}, true
+}
+
+// peekUsername returns the lowercase userData.username of the JSON body, leaving the body intact for the handler.
+func peekUsername(r *http.Request, maxBodySize int) string {
+	if r.Body == nil || maxBodySize <= 0 {
+		return ""
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, int64(maxBodySize)))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+	var body struct {
+		UserData struct {
+			Username string `json:"username"`
+		} `json:"userData"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return ""
+	}
+	return strings.ToLower(body.UserData.Username)
+}
+
+func currentRouteName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		return route.GetName()
+	}
+	return ""
+}
+
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !utils.VerifyAdminApiKey(r) {
+			log.Printf("Unauthorized admin request to %s from %s", r.URL, utils.GetClientIp(r))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
@@ -1,7 +1,43 @@
 package polls
 
+import "switch-polls-backend/db"
+
 type VoteRequest struct {
-	OptionId int      `json:"optionId"`
+	// Deprecated: kept for single-choice clients, use OptionIds instead
+	OptionId  int   `json:"optionId"`
+	OptionIds []int `json:"optionIds"`
+	// Only in score polls, instead of OptionIds
+	Scores   []OptionScore `json:"scores"`
+	UserData UserData      `json:"userData"`
+}
+
+type OptionScore struct {
+	OptionId int `json:"optionId"`
+	Score    int `json:"score"`
+}
+
+// GetOptionIds returns the options chosen on the ballot, falling back to OptionId.
+func (v *VoteRequest) GetOptionIds() []int {
+	if len(v.Scores) > 0 {
+		ids := make([]int, len(v.Scores))
+		for i, s := range v.Scores {
+			ids[i] = s.OptionId
+		}
+		return ids
+	}
+	if len(v.OptionIds) == 0 && v.OptionId != 0 {
+		return []int{v.OptionId}
+	}
+	return v.OptionIds
+}
+
+type WithdrawRequest struct {
+	PollId   int      `json:"pollId"`
+	UserData UserData `json:"userData"`
+}
+
+type ResendRequest struct {
+	PollId   int      `json:"pollId"`
 	UserData UserData `json:"userData"`
 }
 
@@ -9,3 +45,9 @@ type UserData struct {
 	UserAgent string `json:"userAgent"`
 	Username  string `json:"username"`
 }
+
+type PollsListResponse struct {
+	Polls []db.Poll `json:"polls"`
+	// Pass it as the 'cursor' query parameter to get the next page. Empty if there are no more polls.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
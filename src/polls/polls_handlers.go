@@ -12,12 +12,40 @@ import (
 	"time"
 )
 
-func PollHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Context().Value("recaptcha").(utils.RecaptchaVerifyResponse).Action != "poll_get" {
-		log.Printf("PollHandler got invalid recaptcha action from %s", r.RemoteAddr)
-		WriteBadRequestResponse(&w)
+func PollsListHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.ListEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("PollsListHandler failed to read request body: %v", err)
+		return
+	}
+
+	filter, err := ParsePollsFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		resp, _ := utils.PrepareResponse(err.Error())
+		w.Write(resp)
 		return
 	}
+	pageSize := filter.Limit
+	// fetch one more poll to find out whether there is a next page
+	filter.Limit++
+	res, err := db.PollsRepo.ListPolls(filter)
+	if err != nil {
+		log.Println("PollsListHandler cannot list polls", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	list := PollsListResponse{Polls: res}
+	if len(res) > pageSize {
+		list.Polls = res[:pageSize]
+		list.NextCursor = EncodePollsCursor(list.Polls[pageSize-1])
+	}
+	resp, _ := utils.PrepareResponse(list)
+	w.Write(resp)
+}
+
+func PollHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.PollEndpoint.MaxBodySize)
 	if err != nil {
 		log.Printf("PollHandler failed to read request body: %v", err)
@@ -45,14 +73,17 @@ func PollHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
-// TODO: db cleanup raz na x h - usuwa, gdy zachodzi jakis warunek (np. uplynal czas od stworzenia / user juz potwierdzil)
-
 func PollVoteHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Context().Value("recaptcha").(utils.RecaptchaVerifyResponse).Action != "poll_vote" {
-		log.Printf("PollVoteHandler got invalid recaptcha action from %s", r.RemoteAddr)
-		WriteBadRequestResponse(&w)
-		return
-	}
+	handleVote(w, r, false)
+}
+
+// PollVoteChangeHandler accepts a new ballot from a user who may have already voted. Once confirmed,
+// it replaces their earlier confirmed vote.
+func PollVoteChangeHandler(w http.ResponseWriter, r *http.Request) {
+	handleVote(w, r, true)
+}
+
+func handleVote(w http.ResponseWriter, r *http.Request, isChange bool) {
 	body, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.VotesEndpoint.MaxBodySize)
 	if err != nil {
 		log.Printf("PollVoteHandler failed to read request body: %v", err)
@@ -79,7 +110,13 @@ func PollVoteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	option, err := db.PollsRepo.GetPollOption(db.PollOption{Id: reqData.OptionId}, false) //db.GetPollIdByOptionId(reqData.OptionId)
+	optionIds := reqData.GetOptionIds()
+	if len(optionIds) == 0 {
+		log.Println("PollVoteHandler no option chosen")
+		WriteBadRequestResponse(&w)
+		return
+	}
+	option, err := db.PollsRepo.GetPollOption(db.PollOption{Id: optionIds[0]}, false)
 	if err != nil || option.PollId <= 0 {
 		log.Println("PollVoteHandler GetPollOption error: ", err)
 		WriteBadRequestResponse(&w)
@@ -88,14 +125,27 @@ func PollVoteHandler(w http.ResponseWriter, r *http.Request) {
 
 	poll, err := db.PollsRepo.GetPoll(db.Poll{Id: option.PollId}, false)
 	if err != nil {
-		log.Printf("PollVoteHandler cannot get the poll with id %d, vote request by user %s on option %d, error: %v", option.PollId, email, option.PollId, err)
+		log.Printf("PollVoteHandler cannot get the poll with id %d, vote request by user %s on options %v, error: %v", option.PollId, email, optionIds, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if poll.IsReadonly {
+	if WritePollNotOpenResponse(w, poll, http.StatusBadRequest) {
+		return
+	}
+
+	pollOptions, err := db.PollsRepo.GetPollOptions(poll.Id, false)
+	if err != nil {
+		log.Printf("PollVoteHandler cannot get the options of poll %d: %v", poll.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	chosen, err := ValidateBallot(poll, pollOptions, optionIds)
+	if err != nil {
+		log.Printf("PollVoteHandler invalid ballot %v in poll %d: %v", optionIds, poll.Id, err)
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Ta ankieta nie przyjmuje już głosów."))
+		resp, _ := utils.PrepareResponse(err.Error())
+		w.Write(resp)
 		return
 	}
 
@@ -105,7 +155,7 @@ func PollVoteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	voted, err := db.CheckIfUserHasAlreadyVotedById(user.Id, option.PollId)
-	if voted {
+	if voted && !isChange {
 		w.WriteHeader(http.StatusForbidden)
 		w.Write([]byte("Użytkownik oddał już głos"))
 		return
@@ -116,33 +166,182 @@ func PollVoteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// OK
-	vote, err := db.VotesRepo.CreateVote(db.PollVote{
-		UserId:   user.Id,
-		OptionId: reqData.OptionId,
+	selections, err := BuildSelections(poll, chosen, reqData.Scores)
+	if err != nil {
+		log.Printf("PollVoteHandler invalid scores %v in poll %d: %v", reqData.Scores, poll.Id, err)
+		w.WriteHeader(http.StatusBadRequest)
+		resp, _ := utils.PrepareResponse(err.Error())
+		w.Write(resp)
+		return
+	}
+	sourceIp := utils.GetClientIp(r)
+	if WriteEmailNotAllowedResponse(w, email) {
+		return
+	}
+	token := utils.GetNewToken()
+	templateValues := utils.EmailTemplateValues{
+		Receiver:    email,
+		ServiceName: "SWITCH POLLS",
+		VoteOption:  DescribeBallot(poll, chosen, selections), // TODO: limit the length to n chars and append '...' to the end if the threshold is reached
+		PollTitle:   poll.Title,
+		PollId:      strconv.Itoa(poll.Id),
+		Link:        GetConfirmationUrl(token),
+		IsChange:    voted,
+		OptOutLink:  GetOptOutUrl(email),
+	}
+	_, err = db.VotesRepo.CreateVote(db.PollVote{
+		UserId:     user.Id,
+		OptionId:   chosen[0].Id,
+		IsChange:   voted,
+		Selections: selections,
+	}, token, db.OutboxEmail{
+		Recipient: email,
+		SourceIp:  sourceIp,
+		Subject:   config.Cfg.EmailConfig.EmailSubject,
+		Body:      utils.FillEmailTemplate(config.Cfg.EmailConfig.EmailTemplate, templateValues),
+		TextBody:  utils.FillTextEmailTemplate(config.Cfg.EmailConfig.EmailTextTemplate, templateValues),
 	})
+	if WriteEmailThrottledResponse(w, err) {
+		return
+	}
 	if err != nil {
-		log.Printf("PollVoteHandler cannot insert the vote of user %s on poll option %d. error: %v", email, reqData.OptionId, err)
+		log.Printf("PollVoteHandler cannot insert the vote of user %s on poll options %v. error: %v", email, optionIds, err)
 		WriteBadRequestResponse(&w)
 		return
 	}
-	token, err := CreateVoteToken(vote.Id)
 
-	template := utils.FillEmailTemplate(utils.EmailTemplateValues{
+	w.WriteHeader(http.StatusCreated)
+}
+
+// PollResendHandler sends the confirmation email of the user's pending vote again with a new token.
+// The earlier tokens of the vote stop working.
+func PollResendHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.ResendEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("PollResendHandler failed to read request body: %v", err)
+		return
+	}
+
+	var reqData ResendRequest
+	err = json.Unmarshal(body, &reqData)
+	if err != nil {
+		log.Println("PollResendHandler failed to unmarshal body request data", err)
+		WriteBadRequestResponse(&w)
+		return
+	}
+	if !utils.ValidateUsername(reqData.UserData.Username) {
+		log.Println("PollResendHandler invalid username format")
+		WriteBadRequestResponse(&w)
+		return
+	}
+	email := UsernameToEmail(reqData.UserData.Username)
+
+	poll, err := db.PollsRepo.GetPoll(db.Poll{Id: reqData.PollId}, false)
+	if err != nil {
+		log.Printf("PollResendHandler cannot get the poll with id %d: %v", reqData.PollId, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if WritePollNotOpenResponse(w, poll, http.StatusBadRequest) {
+		return
+	}
+
+	var vote *db.PollVote
+	user, err := db.UsersRepo.GetUser(db.User{Email: email}, false)
+	if err == nil {
+		vote, err = db.GetPendingVote(user.Id, poll.Id)
+	}
+	if err != nil || vote == nil {
+		log.Printf("PollResendHandler no pending vote of user %s in poll %d (err: %v)", email, poll.Id, err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Użytkownik nie ma niepotwierdzonego głosu"))
+		return
+	}
+
+	age, hasToken, err := db.GetLastTokenAge(vote.Id, db.ConfirmationPurposeVote)
+	if err != nil {
+		log.Printf("PollResendHandler cannot get the token age of vote %d: %v", vote.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if cooldown := config.Cfg.WebConfig.ConfirmationResendCooldown; hasToken && age < cooldown {
+		log.Printf("PollResendHandler user %s asked for a resend %d seconds after the last email", email, age)
+		w.Header().Set("Retry-After", strconv.Itoa(cooldown-age))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Poczekaj przed ponownym wysłaniem maila"))
+		return
+	}
+
+	selections, err := db.VotesRepo.GetVoteSelections(vote.Id)
+	if err != nil {
+		log.Printf("PollResendHandler cannot get the selections of vote %d: %v", vote.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pollOptions, err := db.PollsRepo.GetPollOptions(poll.Id, false)
+	if err != nil {
+		log.Printf("PollResendHandler cannot get the options of poll %d: %v", poll.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	optionIds := make([]int, len(selections))
+	for i, selection := range selections {
+		optionIds[i] = selection.OptionId
+	}
+	chosen, err := ValidateBallot(poll, pollOptions, optionIds)
+	if err != nil {
+		// the poll has been edited since the vote was cast
+		log.Printf("PollResendHandler the ballot of vote %d is no longer valid: %v", vote.Id, err)
+		w.WriteHeader(http.StatusConflict)
+		resp, _ := utils.PrepareResponse(err.Error())
+		w.Write(resp)
+		return
+	}
+	voted, err := db.CheckIfUserHasAlreadyVotedById(user.Id, poll.Id)
+	if err != nil {
+		log.Println("PollResendHandler cannot check if user has already voted, error: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if voted && !vote.IsChange {
+		// the confirmation would be rejected anyway
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Użytkownik oddał już głos"))
+		return
+	}
+
+	sourceIp := utils.GetClientIp(r)
+	if WriteEmailNotAllowedResponse(w, email) {
+		return
+	}
+	token := utils.GetNewToken()
+	templateValues := utils.EmailTemplateValues{
 		Receiver:    email,
 		ServiceName: "SWITCH POLLS",
-		VoteOption:  option.Content, // TODO: limit the length to n chars and append '...' to the end if the threshold is reached
+		VoteOption:  DescribeBallot(poll, chosen, selections),
 		PollTitle:   poll.Title,
 		PollId:      strconv.Itoa(poll.Id),
 		Link:        GetConfirmationUrl(token),
+		IsChange:    voted,
+		OptOutLink:  GetOptOutUrl(email),
+	}
+	err = db.VotesRepo.RotateConfirmationToken(vote.Id, token, db.OutboxEmail{
+		Recipient: email,
+		SourceIp:  sourceIp,
+		Subject:   config.Cfg.EmailConfig.EmailSubject,
+		Body:      utils.FillEmailTemplate(config.Cfg.EmailConfig.EmailTemplate, templateValues),
+		TextBody:  utils.FillTextEmailTemplate(config.Cfg.EmailConfig.EmailTextTemplate, templateValues),
 	})
-	err = utils.SendEmail(&config.Cfg.EmailConfig, config.Cfg.EmailConfig.EmailSubject, template, email)
+	if WriteEmailThrottledResponse(w, err) {
+		return
+	}
 	if err != nil {
-		log.Println("PollVoteHandler cannot send an email to "+email, err)
+		log.Printf("PollResendHandler cannot resend the confirmation of vote %d: %v", vote.Id, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusAccepted)
 }
 
 func PollConfirmHandler(w http.ResponseWriter, r *http.Request) {
@@ -160,7 +359,7 @@ func PollConfirmHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = VerifyToken(token)
+	err = VerifyToken(token, db.ConfirmationPurposeVote)
 	if err != nil {
 		log.Println("PollConfirmHandler invalid token: ", err)
 		WriteBadRequestResponse(&w)
@@ -182,15 +381,13 @@ func PollConfirmHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if poll.IsReadonly {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("Ta ankieta nie przyjmuje już głosów."))
+	if WritePollNotOpenResponse(w, poll, http.StatusForbidden) {
 		return
 	}
 
-	err = db.ChangeConfirmationStatus(cnf.VoteId, time.Now().Unix())
+	err = db.ConfirmVote(cnf.VoteId, time.Now().Unix())
 	if err != nil {
-		log.Println("PollConfirmHandler cannot change confirmation status", err)
+		log.Println("PollConfirmHandler cannot confirm the vote", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -202,12 +399,157 @@ func PollConfirmHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(res)
 }
 
-func PollResultsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Context().Value("recaptcha").(utils.RecaptchaVerifyResponse).Action != "poll_results_get" {
-		log.Printf("PollResultsHandler got invalid recaptcha action from %s", r.RemoteAddr)
+// PollWithdrawHandler sends the user an email with the link withdrawing their confirmed vote.
+func PollWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.WithdrawEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("PollWithdrawHandler failed to read request body: %v", err)
+		return
+	}
+
+	var reqData WithdrawRequest
+	err = json.Unmarshal(body, &reqData)
+	if err != nil {
+		log.Println("PollWithdrawHandler failed to unmarshal body request data", err)
+		WriteBadRequestResponse(&w)
+		return
+	}
+	if !utils.ValidateUsername(reqData.UserData.Username) {
+		log.Println("PollWithdrawHandler invalid username format")
+		WriteBadRequestResponse(&w)
+		return
+	}
+	email := UsernameToEmail(reqData.UserData.Username)
+
+	poll, err := db.PollsRepo.GetPoll(db.Poll{Id: reqData.PollId}, false)
+	if err != nil {
+		log.Printf("PollWithdrawHandler cannot get the poll with id %d: %v", reqData.PollId, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if WritePollNotOpenResponse(w, poll, http.StatusBadRequest) {
+		return
+	}
+
+	var vote *db.PollVote
+	user, err := db.UsersRepo.GetUser(db.User{Email: email}, false)
+	if err == nil {
+		vote, err = db.GetConfirmedVote(user.Id, poll.Id)
+	}
+	if err != nil || vote == nil {
+		log.Printf("PollWithdrawHandler no confirmed vote of user %s in poll %d (err: %v)", email, poll.Id, err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Użytkownik nie oddał głosu"))
+		return
+	}
+
+	sourceIp := utils.GetClientIp(r)
+	if WriteEmailNotAllowedResponse(w, email) {
+		return
+	}
+	token := utils.GetNewToken()
+	templateValues := utils.EmailTemplateValues{
+		Receiver:    email,
+		ServiceName: "SWITCH POLLS",
+		PollTitle:   poll.Title,
+		PollId:      strconv.Itoa(poll.Id),
+		Link:        GetWithdrawalConfirmationUrl(token),
+		OptOutLink:  GetOptOutUrl(email),
+	}
+	err = db.VotesRepo.CreateWithdrawalToken(vote.Id, token, db.OutboxEmail{
+		Recipient: email,
+		SourceIp:  sourceIp,
+		Subject:   config.Cfg.EmailConfig.WithdrawalEmailSubject,
+		Body:      utils.FillEmailTemplate(config.Cfg.EmailConfig.WithdrawalEmailTemplate, templateValues),
+		TextBody:  utils.FillTextEmailTemplate(config.Cfg.EmailConfig.WithdrawalEmailTextTemplate, templateValues),
+	})
+	if WriteEmailThrottledResponse(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("PollWithdrawHandler cannot create the withdrawal token of vote %d: %v", vote.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// PollConfirmWithdrawalHandler removes the confirmed vote the token was issued for.
+func PollConfirmWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.ConfirmWithdrawalEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("PollConfirmWithdrawalHandler error when reading request body %v", err)
+		return
+	}
+	token := mux.Vars(r)["token"]
+	err = VerifyToken(token, db.ConfirmationPurposeWithdraw)
+	if err != nil {
+		log.Println("PollConfirmWithdrawalHandler invalid token: ", err)
+		WriteBadRequestResponse(&w)
+		return
+	}
+
+	cnf, err := db.GetConfirmationByToken(token)
+	if err != nil {
+		log.Println("PollConfirmWithdrawalHandler cannot get confirmation by token", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	vote, err := db.VotesRepo.GetVote(db.PollVote{Id: cnf.VoteId})
+	if err != nil {
+		log.Println("PollConfirmWithdrawalHandler cannot get the vote", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	option, _ := db.PollsRepo.GetPollOption(db.PollOption{Id: vote.OptionId}, false)
+	poll, err := db.PollsRepo.GetPoll(db.Poll{Id: option.PollId}, false)
+	if err != nil {
+		log.Printf("PollConfirmWithdrawalHandler cannot get the poll with id %d, error: %v", option.PollId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if WritePollNotOpenResponse(w, poll, http.StatusForbidden) {
+		return
+	}
+
+	if err = db.VotesRepo.DeleteVote(vote.Id); err != nil {
+		log.Println("PollConfirmWithdrawalHandler cannot delete the vote", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, _ := utils.PrepareResponse("Wycofano glos!")
+	w.Header().Set("Location", config.Cfg.WebConfig.TokenVerificationRedirectLocation+strconv.Itoa(poll.Id))
+	w.WriteHeader(http.StatusSeeOther)
+	w.Write(res)
+}
+
+// PollOptOutHandler stops all further emails to the address the signed opt-out link was sent to.
+func PollOptOutHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.OptOutEndpoint.MaxBodySize)
+	if err != nil {
+		log.Printf("PollOptOutHandler error when reading request body %v", err)
+		return
+	}
+	email, err := utils.VerifyOptOutToken(config.Cfg.EmailConfig.OptOutSecret, mux.Vars(r)["token"])
+	if err != nil {
+		log.Println("PollOptOutHandler invalid token: ", err)
 		WriteBadRequestResponse(&w)
 		return
 	}
+	if err = db.OptOut(email); err != nil {
+		log.Println("PollOptOutHandler cannot opt out", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("PollOptOutHandler %s opted out of the emails", email)
+
+	res, _ := utils.PrepareResponse("Nie będziesz już otrzymywać maili z serwisu.")
+	w.Write(res)
+}
+
+func PollResultsHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := LimitBodySize(w, r, config.Cfg.WebConfig.EndpointsLimits.Polls.ResultsEndpoint.MaxBodySize)
 	if err != nil {
 		log.Printf("PollResultsHandler error when reading request body %v", err)
@@ -226,12 +568,23 @@ func PollResultsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	summary, err := db.PrepareResultsSummary(poll.Id)
+	summary, err := db.PrepareResultsSummary(poll.Id, poll.VotingMode == db.VotingModeRanked)
 	if err != nil {
 		log.Println("PollResultsHandler results summary error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	switch poll.VotingMode {
+	case db.VotingModeRanked:
+		err = PrepareRankedResults(poll, summary)
+	case db.VotingModeScore:
+		err = PrepareScoreResults(poll, summary)
+	}
+	if err != nil {
+		log.Printf("PollResultsHandler %s results error: %v", poll.VotingMode, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	resp, _ := utils.PrepareResponse(summary)
 	w.Write(resp)
 }
@@ -1,14 +1,24 @@
 package polls
 
 import (
+	"encoding/base64"
 	"errors"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"switch-polls-backend/config"
 	"switch-polls-backend/db"
+	"switch-polls-backend/tally"
 	"switch-polls-backend/utils"
+	"time"
+)
+
+const (
+	DefaultPollsPageSize = 20
+	MaxPollsPageSize     = 100
 )
 
 func WriteBadRequestResponse(w *http.ResponseWriter) {
@@ -25,12 +35,10 @@ func UsernameToEmail(username string) string {
 	return username + "@" + config.Cfg.EmailConfig.OrganizationDomain
 }
 
-func CreateVoteToken(voteId int) (string, error) {
-	token := utils.GetNewToken()
-	return token, db.InsertToken(token, voteId)
-}
-
-func VerifyToken(token string) error {
+// VerifyToken checks whether the token can be used for the given purpose: vote tokens only for
+// unconfirmed votes and withdrawal tokens only for confirmed ones. A vote token of a user who has already
+// voted in the poll is accepted only if the vote was cast as a change.
+func VerifyToken(token string, purpose db.ConfirmationPurpose) error {
 	if !utils.IsAlphaWithDash(token) {
 		return errors.New("invalid character in token")
 	}
@@ -42,42 +50,78 @@ func VerifyToken(token string) error {
 	if err != nil {
 		return err
 	}
+	if cnf.Purpose != purpose {
+		return errors.New("the token is not meant for " + string(purpose))
+	}
+	if ttl := config.Cfg.WebConfig.ConfirmationTokenTTL; ttl > 0 {
+		age, err := db.GetConfirmationAge(token)
+		if err != nil {
+			return err
+		}
+		if age > ttl {
+			return errors.New("the token has expired")
+		}
+	}
 	vote, err := db.VotesRepo.GetVote(db.PollVote{Id: cnf.VoteId})
 	if err != nil {
 		return err
 	}
-	option, err := db.PollsRepo.GetPollOption(db.PollOption{Id: vote.OptionId}, false)
-	if err != nil {
-		return err
+	if purpose == db.ConfirmationPurposeVote && vote.ConfirmedAt.Valid {
+		return errors.New("the vote has already been confirmed")
 	}
-	res, err := db.CheckIfUserHasAlreadyVotedById(vote.UserId, option.PollId)
-	if err != nil {
-		return err
+	if purpose == db.ConfirmationPurposeVote && !vote.IsChange {
+		option, err := db.PollsRepo.GetPollOption(db.PollOption{Id: vote.OptionId}, false)
+		if err != nil {
+			return err
+		}
+		voted, err := db.CheckIfUserHasAlreadyVotedById(vote.UserId, option.PollId)
+		if err != nil {
+			return err
+		}
+		if voted {
+			return errors.New("user has already voted")
+		}
 	}
-	if res {
-		return errors.New("user has already voted")
+	if purpose == db.ConfirmationPurposeWithdraw && !vote.ConfirmedAt.Valid {
+		return errors.New("the vote is not confirmed")
 	}
 	return nil
 }
 
 func GetConfirmationUrl(token string) string {
+	return getApiUrl("/polls/confirm_vote/" + token)
+}
+
+func GetWithdrawalConfirmationUrl(token string) string {
+	return getApiUrl("/polls/confirm_withdrawal/" + token)
+}
+
+// GetOptOutUrl returns the link stopping the emails to the address or an empty string if opting out is not configured.
+func GetOptOutUrl(email string) string {
+	secret := config.Cfg.EmailConfig.OptOutSecret
+	if secret == "" {
+		return ""
+	}
+	return getApiUrl("/polls/opt_out/" + utils.SignOptOutToken(secret, email))
+}
+
+func getApiUrl(path string) string {
 	port := ""
 	if config.Cfg.WebConfig.Port != 80 && config.Cfg.WebConfig.Port != 443 {
 		port = ":" + strconv.Itoa(int(config.Cfg.WebConfig.Port))
 	}
-	return config.Cfg.WebConfig.Protocol + "://" + config.Cfg.WebConfig.Domain + port + config.Cfg.WebConfig.ApiPrefix + "/polls/confirm_vote/" + token
+	return config.Cfg.WebConfig.Protocol + "://" + config.Cfg.WebConfig.Domain + port + config.Cfg.WebConfig.ApiPrefix + path
 }
 
 func ReadBody(r *http.Request, maxBodySize int) ([]byte, error) {
-	bigBody := make([]byte, maxBodySize+1)
-	n, err := r.Body.Read(bigBody)
-	if err != nil && err != io.EOF {
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxBodySize)+1))
+	if err != nil {
 		return nil, err
 	}
-	if n > maxBodySize {
+	if len(body) > maxBodySize {
 		return nil, errors.New("max body size limit exceeded")
 	}
-	return bigBody[:n], nil
+	return body, nil
 }
 
 func LimitBodySize(w http.ResponseWriter, r *http.Request, maxBodySize int) ([]byte, error) {
@@ -90,3 +134,245 @@ func LimitBodySize(w http.ResponseWriter, r *http.Request, maxBodySize int) ([]b
 	}
 	return b, err
 }
+
+// ParsePollsFilter builds the filter of the polls list from the query parameters:
+// state (open|upcoming|readonly), created_after, created_before (RFC 3339), order (asc|desc),
+// limit, offset or cursor (mutually exclusive) and with_options (true|false).
+func ParsePollsFilter(q url.Values) (db.PollsFilter, error) {
+	var err error
+	filter := db.PollsFilter{Limit: DefaultPollsPageSize}
+
+	switch q.Get("state") {
+	case "":
+	case "open":
+		filter.State = db.PollStateOpen
+	case "upcoming":
+		filter.State = db.PollStateUpcoming
+	case "readonly":
+		filter.State = db.PollStateReadonly
+	default:
+		return filter, errors.New("invalid state")
+	}
+	if v := q.Get("created_after"); v != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("invalid created_after date")
+		}
+	}
+	if v := q.Get("created_before"); v != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("invalid created_before date")
+		}
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("invalid order")
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > MaxPollsPageSize {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(MaxPollsPageSize))
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			return filter, errors.New("invalid offset")
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if filter.Offset != 0 {
+			return filter, errors.New("cursor and offset cannot be used together")
+		}
+		if filter.After, err = DecodePollsCursor(v); err != nil {
+			return filter, errors.New("invalid cursor")
+		}
+	}
+	if v := q.Get("with_options"); v != "" {
+		if filter.WithOptions, err = strconv.ParseBool(v); err != nil {
+			return filter, errors.New("invalid with_options")
+		}
+	}
+	return filter, nil
+}
+
+func EncodePollsCursor(poll db.Poll) string {
+	raw := strconv.FormatInt(poll.CreateDate.UnixNano(), 10) + ":" + strconv.Itoa(poll.Id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodePollsCursor(cursor string) (*db.PollsCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return nil, errors.New("malformed cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &db.PollsCursor{CreateDate: time.Unix(0, nanos).UTC(), Id: id}, nil
+}
+
+// WritePollNotOpenResponse writes the response for a vote or confirmation attempt made outside the voting period.
+// Returns false if the poll accepts votes.
+func WritePollNotOpenResponse(w http.ResponseWriter, poll *db.Poll, status int) bool {
+	switch poll.State(time.Now()) {
+	case db.PollStateUpcoming:
+		w.WriteHeader(status)
+		w.Write([]byte("Ta ankieta nie przyjmuje jeszcze głosów."))
+		return true
+	case db.PollStateReadonly:
+		w.WriteHeader(status)
+		w.Write([]byte("Ta ankieta nie przyjmuje już głosów."))
+		return true
+	}
+	return false
+}
+
+// ValidateBallot checks whether the chosen options form a valid ballot in the poll and returns them in the chosen order.
+func ValidateBallot(poll *db.Poll, pollOptions []db.PollOption, optionIds []int) ([]db.PollOption, error) {
+	minSelections, maxSelections := 1, 1
+	switch poll.VotingMode {
+	case db.VotingModeMultiple, db.VotingModeRanked:
+		minSelections, maxSelections = poll.MinSelections, poll.MaxSelections
+	case db.VotingModeScore:
+		// every option has to be scored
+		minSelections, maxSelections = len(pollOptions), len(pollOptions)
+	}
+	if len(optionIds) < minSelections || len(optionIds) > maxSelections {
+		return nil, errors.New("the number of chosen options must be between " + strconv.Itoa(minSelections) + " and " + strconv.Itoa(maxSelections))
+	}
+
+	byId := make(map[int]db.PollOption)
+	for _, opt := range pollOptions {
+		byId[opt.Id] = opt
+	}
+	chosen := make([]db.PollOption, 0, len(optionIds))
+	seen := make(map[int]bool)
+	for _, id := range optionIds {
+		opt, ok := byId[id]
+		if !ok {
+			return nil, errors.New("option " + strconv.Itoa(id) + " does not belong to the poll")
+		}
+		if seen[id] {
+			return nil, errors.New("option " + strconv.Itoa(id) + " was chosen more than once")
+		}
+		seen[id] = true
+		chosen = append(chosen, opt)
+	}
+	return chosen, nil
+}
+
+// BuildSelections turns the validated ballot into the selections stored in the database. In score polls
+// the scores have to be given in the same order as the chosen options.
+func BuildSelections(poll *db.Poll, chosen []db.PollOption, scores []OptionScore) ([]db.VoteSelection, error) {
+	if poll.VotingMode == db.VotingModeScore && len(scores) != len(chosen) {
+		return nil, errors.New("every option has to be scored")
+	}
+	selections := make([]db.VoteSelection, len(chosen))
+	for i, opt := range chosen {
+		selections[i] = db.VoteSelection{OptionId: opt.Id, Position: i}
+		if poll.VotingMode != db.VotingModeScore {
+			continue
+		}
+		score := scores[i].Score
+		if score < poll.ScoreMin || score > poll.ScoreMax {
+			return nil, errors.New("the scores must be between " + strconv.Itoa(poll.ScoreMin) + " and " + strconv.Itoa(poll.ScoreMax))
+		}
+		selections[i].Score = &score
+	}
+	return selections, nil
+}
+
+// DescribeBallot returns the human-readable list of the chosen options used in the confirmation email.
+func DescribeBallot(poll *db.Poll, chosen []db.PollOption, selections []db.VoteSelection) string {
+	contents := make([]string, len(chosen))
+	for i, opt := range chosen {
+		switch poll.VotingMode {
+		case db.VotingModeRanked:
+			contents[i] = strconv.Itoa(i+1) + ". " + opt.Content
+		case db.VotingModeScore:
+			contents[i] = opt.Content + ": " + strconv.Itoa(*selections[i].Score)
+		default:
+			contents[i] = opt.Content
+		}
+	}
+	return strings.Join(contents, ", ")
+}
+
+// PrepareScoreResults adds the score statistics of every option to the summary.
+func PrepareScoreResults(poll *db.Poll, summary *db.ResultsSummary) error {
+	scores, err := db.GetConfirmedScores(poll.Id)
+	if err != nil {
+		return err
+	}
+	for i := range summary.Summary {
+		stats := tally.ScoreStatistics(scores[summary.Summary[i].Id], poll.ScoreMin, poll.ScoreMax)
+		summary.Summary[i].Score = &stats
+	}
+	return nil
+}
+
+// PrepareRankedResults adds the instant-runoff or, for multi-seat polls, the STV count to the summary.
+func PrepareRankedResults(poll *db.Poll, summary *db.ResultsSummary) error {
+	options, err := db.PollsRepo.GetPollOptions(poll.Id, false)
+	if err != nil {
+		return err
+	}
+	candidates := make([]int, len(options))
+	for i, opt := range options {
+		candidates[i] = opt.Id
+	}
+	ballots, err := db.GetConfirmedBallots(poll.Id)
+	if err != nil {
+		return err
+	}
+	if poll.Seats > 1 {
+		res := tally.SingleTransferableVote(candidates, ballots, poll.Seats, int64(poll.Id))
+		summary.SingleTransferableVote = &res
+	} else {
+		res := tally.InstantRunoff(candidates, ballots)
+		summary.InstantRunoff = &res
+	}
+	return nil
+}
+
+// WriteEmailNotAllowedResponse writes the response for a request which would send an email to a recipient who has
+// opted out. Returns false if the email can be sent. The email limits are enforced when the email is enqueued,
+// see WriteEmailThrottledResponse.
+func WriteEmailNotAllowedResponse(w http.ResponseWriter, recipient string) bool {
+	optedOut, err := db.IsOptedOut(recipient)
+	if err != nil {
+		log.Printf("WriteEmailNotAllowedResponse cannot check the opt-out of %s: %v", recipient, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	if optedOut {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Użytkownik zrezygnował z otrzymywania maili."))
+		return true
+	}
+	return false
+}
+
+// WriteEmailThrottledResponse writes the response for a request whose email was not enqueued because of the email
+// limits. Returns false if err is not caused by the limits.
+func WriteEmailThrottledResponse(w http.ResponseWriter, err error) bool {
+	var throttled *db.EmailThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	log.Printf("Throttled an email for %d seconds: %v", throttled.RetryAfter, err)
+	w.Header().Set("Retry-After", strconv.Itoa(throttled.RetryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("Wysłano zbyt wiele maili. Spróbuj ponownie później."))
+	return true
+}
@@ -0,0 +1,227 @@
+package polls
+
+import (
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"switch-polls-backend/db"
+	"testing"
+	"time"
+)
+
+func TestParsePollsFilter(t *testing.T) {
+	cursor := EncodePollsCursor(db.Poll{Id: 7, CreateDate: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)})
+	cases := []struct {
+		query string
+		valid bool
+		check func(f db.PollsFilter) bool
+	}{
+		{"", true, func(f db.PollsFilter) bool {
+			return f.Limit == DefaultPollsPageSize && f.Offset == 0 && f.State == db.PollStateAny && !f.Descending && f.After == nil
+		}},
+		{"state=open&order=desc", true, func(f db.PollsFilter) bool { return f.State == db.PollStateOpen && f.Descending }},
+		{"state=upcoming", true, func(f db.PollsFilter) bool { return f.State == db.PollStateUpcoming }},
+		{"state=readonly&order=asc", true, func(f db.PollsFilter) bool { return f.State == db.PollStateReadonly && !f.Descending }},
+		{"state=closed", false, nil},
+		{"state=OPEN", false, nil},
+		{"order=random", false, nil},
+		{"order=DESC", false, nil},
+		{"limit=0", false, nil},
+		{"limit=101", false, nil},
+		{"limit=abc", false, nil},
+		{"limit=100&offset=40", true, func(f db.PollsFilter) bool { return f.Limit == 100 && f.Offset == 40 }},
+		{"offset=-1", false, nil},
+		{"created_after=yesterday", false, nil},
+		{"created_before=2024-03-01T12:00:00Z", true, func(f db.PollsFilter) bool {
+			return f.CreatedBefore.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+		}},
+		{"with_options=maybe", false, nil},
+		{"cursor=" + cursor + "&limit=5", true, func(f db.PollsFilter) bool { return f.After != nil && f.After.Id == 7 && f.Limit == 5 }},
+		{"cursor=" + cursor + "&offset=10", false, nil},
+		{"offset=10&cursor=" + cursor, false, nil},
+		{"cursor=%21%21%21", false, nil},
+		{"cursor=" + base64.RawURLEncoding.EncodeToString([]byte("12345")), false, nil},
+	}
+	for _, c := range cases {
+		q, err := url.ParseQuery(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := ParsePollsFilter(q)
+		if (err == nil) != c.valid {
+			t.Errorf("%q: expected valid=%v, got error %v", c.query, c.valid, err)
+			continue
+		}
+		if c.check != nil && !c.check(filter) {
+			t.Errorf("%q: unexpected filter %+v", c.query, filter)
+		}
+	}
+}
+
+func TestPollsCursorRoundTrip(t *testing.T) {
+	poll := db.Poll{Id: 42, CreateDate: time.Date(2023, 10, 5, 8, 30, 15, 123456789, time.UTC)}
+	cursor, err := DecodePollsCursor(EncodePollsCursor(poll))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cursor.Id != poll.Id || !cursor.CreateDate.Equal(poll.CreateDate) {
+		t.Errorf("expected %v/%d, got %v/%d", poll.CreateDate, poll.Id, cursor.CreateDate, cursor.Id)
+	}
+}
+
+func TestDecodePollsCursorRejectsMalformed(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	for _, cursor := range []string{
+		"",
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("1:23")), // padded
+		encode("1"),
+		encode("1:2:3"),
+		encode("abc:2"),
+		encode("1:abc"),
+		encode(":"),
+	} {
+		if _, err := DecodePollsCursor(cursor); err == nil {
+			t.Errorf("expected an error for %q", cursor)
+		}
+	}
+}
+
+func ballotPoll(mode db.VotingMode, minSelections, maxSelections int) (*db.Poll, []db.PollOption) {
+	poll := &db.Poll{Id: 1, VotingMode: mode, MinSelections: minSelections, MaxSelections: maxSelections, ScoreMin: 1, ScoreMax: 5}
+	options := []db.PollOption{{Id: 11, PollId: 1, Content: "A"}, {Id: 12, PollId: 1, Content: "B"}, {Id: 13, PollId: 1, Content: "C"}}
+	return poll, options
+}
+
+func TestValidateBallot(t *testing.T) {
+	cases := []struct {
+		name      string
+		mode      db.VotingMode
+		optionIds []int
+		expected  []int
+	}{
+		{"single", db.VotingModeSingle, []int{12}, []int{12}},
+		{"single without options", db.VotingModeSingle, []int{}, nil},
+		{"single with two options", db.VotingModeSingle, []int{11, 12}, nil},
+		{"multiple", db.VotingModeMultiple, []int{13, 11}, []int{13, 11}},
+		{"multiple below min", db.VotingModeMultiple, []int{}, nil},
+		{"multiple above max", db.VotingModeMultiple, []int{11, 12, 13}, nil},
+		{"ranked keeps the order", db.VotingModeRanked, []int{12, 11}, []int{12, 11}},
+		{"ranked duplicate", db.VotingModeRanked, []int{12, 12}, nil},
+		{"option of another poll", db.VotingModeMultiple, []int{11, 21}, nil},
+		{"unknown option", db.VotingModeSingle, []int{0}, nil},
+		{"score every option", db.VotingModeScore, []int{11, 12, 13}, []int{11, 12, 13}},
+		{"score missing option", db.VotingModeScore, []int{11, 12}, nil},
+		{"score duplicate", db.VotingModeScore, []int{11, 12, 12}, nil},
+	}
+	for _, c := range cases {
+		poll, options := ballotPoll(c.mode, 1, 2)
+		chosen, err := ValidateBallot(poll, options, c.optionIds)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", c.name, chosen)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		ids := make([]int, len(chosen))
+		for i, opt := range chosen {
+			ids[i] = opt.Id
+		}
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, ids)
+		}
+	}
+}
+
+func TestBuildSelections(t *testing.T) {
+	cases := []struct {
+		name     string
+		mode     db.VotingMode
+		scores   []int
+		expected []int
+	}{
+		{"ranked positions", db.VotingModeRanked, nil, nil},
+		{"scores", db.VotingModeScore, []int{5, 1, 3}, []int{5, 1, 3}},
+		{"score below min", db.VotingModeScore, []int{0, 1, 3}, nil},
+		{"score above max", db.VotingModeScore, []int{5, 6, 3}, nil},
+		{"missing score", db.VotingModeScore, []int{5, 1}, nil},
+	}
+	for _, c := range cases {
+		poll, options := ballotPoll(c.mode, 1, 3)
+		scores := make([]OptionScore, len(c.scores))
+		for i, score := range c.scores {
+			scores[i] = OptionScore{OptionId: options[i].Id, Score: score}
+		}
+		selections, err := BuildSelections(poll, options, scores)
+		if c.mode == db.VotingModeScore && c.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", c.name, selections)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		for i, s := range selections {
+			if s.OptionId != options[i].Id || s.Position != i {
+				t.Errorf("%s: unexpected selection %d: %+v", c.name, i, s)
+			}
+			if c.expected == nil && s.Score != nil {
+				t.Errorf("%s: unexpected score in selection %d", c.name, i)
+			}
+			if c.expected != nil && (s.Score == nil || *s.Score != c.expected[i]) {
+				t.Errorf("%s: expected score %d in selection %d, got %v", c.name, c.expected[i], i, s.Score)
+			}
+		}
+	}
+}
+
+func TestDescribeBallot(t *testing.T) {
+	cases := []struct {
+		mode     db.VotingMode
+		expected string
+	}{
+		{db.VotingModeSingle, "B"},
+		{db.VotingModeMultiple, "B, A"},
+		{db.VotingModeRanked, "1. B, 2. A"},
+		{db.VotingModeScore, "B: 4, A: 2"},
+	}
+	for _, c := range cases {
+		poll, options := ballotPoll(c.mode, 1, 2)
+		chosen := []db.PollOption{options[1], options[0]}
+		if c.mode == db.VotingModeSingle {
+			chosen = chosen[:1]
+		}
+		four, two := 4, 2
+		selections := []db.VoteSelection{{OptionId: 12, Score: &four}, {OptionId: 11, Score: &two}}
+		if got := DescribeBallot(poll, chosen, selections); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.mode, c.expected, got)
+		}
+	}
+}
+
+func TestGetOptionIds(t *testing.T) {
+	cases := []struct {
+		name     string
+		req      VoteRequest
+		expected []int
+	}{
+		{"deprecated option id", VoteRequest{OptionId: 7}, []int{7}},
+		{"option ids", VoteRequest{OptionIds: []int{3, 1}}, []int{3, 1}},
+		{"option ids take precedence", VoteRequest{OptionId: 7, OptionIds: []int{3}}, []int{3}},
+		{"scores", VoteRequest{OptionId: 7, OptionIds: []int{3}, Scores: []OptionScore{{OptionId: 5, Score: 1}, {OptionId: 6}}}, []int{5, 6}},
+		{"nothing chosen", VoteRequest{}, nil},
+	}
+	for _, c := range cases {
+		if got := c.req.GetOptionIds(); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
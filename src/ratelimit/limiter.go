@@ -0,0 +1,118 @@
+// Package ratelimit implements in-memory token buckets limiting how often a client can call an endpoint.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate describes a token bucket: it holds up to Burst tokens and is refilled with PerSecond tokens every second.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Status describes the bucket after a request, in the terms of the RateLimit-* headers.
+type Status struct {
+	Allowed bool
+	// The bucket size
+	Limit int
+	// The number of requests which can be made right now
+	Remaining int
+	// Seconds until a request is allowed again if Remaining is 0, otherwise until the bucket is full
+	Reset int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	fullAt time.Time
+}
+
+// Limiter keeps one bucket per key. The buckets which have refilled completely are dropped by Evict.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Limit is the bucket of a key refilled at a rate.
+type Limit struct {
+	Key  string
+	Rate Rate
+}
+
+// Allow takes a token from the bucket of the key, if there is one.
+func (l *Limiter) Allow(key string, rate Rate, now time.Time) Status {
+	return l.AllowAll([]Limit{{Key: key, Rate: rate}}, now)[0]
+}
+
+// AllowAll takes a token from every bucket only if each of them has one, so that a request denied by one limit
+// does not count against the others. The statuses are returned in the order of the limits.
+func (l *Limiter) AllowAll(limits []Limit, now time.Time) []Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := make([]*bucket, len(limits))
+	allowed := true
+	for i, limit := range limits {
+		burst := float64(limit.Rate.Burst)
+		b, ok := l.buckets[limit.Key]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			l.buckets[limit.Key] = b
+		}
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate.PerSecond)
+		b.last = now
+		buckets[i] = b
+		allowed = allowed && b.tokens >= 1
+	}
+
+	statuses := make([]Status, len(limits))
+	for i, limit := range limits {
+		b, rate, burst := buckets[i], limit.Rate, float64(limit.Rate.Burst)
+		status := Status{Limit: rate.Burst}
+		if allowed {
+			b.tokens--
+			status.Allowed = true
+		}
+		status.Remaining = int(b.tokens)
+		if status.Remaining == 0 {
+			status.Reset = secondsUntil(1-b.tokens, rate.PerSecond)
+		} else {
+			status.Reset = secondsUntil(burst-b.tokens, rate.PerSecond)
+		}
+		b.fullAt = now.Add(time.Duration((burst - b.tokens) / rate.PerSecond * float64(time.Second)))
+		statuses[i] = status
+	}
+	return statuses
+}
+
+// Evict removes the buckets which are full at the given time, so they are no different from new ones.
+func (l *Limiter) Evict(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if !now.Before(b.fullAt) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets kept in memory.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+func secondsUntil(tokens float64, perSecond float64) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / perSecond))
+}
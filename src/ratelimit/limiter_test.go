@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	l := NewLimiter()
+	rate := Rate{PerSecond: 0.5, Burst: 3}
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		s := l.Allow("ip:192.0.2.1", rate, now)
+		if !s.Allowed || s.Remaining != i || s.Limit != 3 {
+			t.Fatalf("request %d: unexpected status %+v", 3-i, s)
+		}
+	}
+	s := l.Allow("ip:192.0.2.1", rate, now)
+	if s.Allowed || s.Remaining != 0 || s.Reset != 2 {
+		t.Errorf("expected the request to be limited for 2 seconds, got %+v", s)
+	}
+	if s = l.Allow("ip:192.0.2.2", rate, now); !s.Allowed {
+		t.Errorf("the buckets of other keys must not be affected, got %+v", s)
+	}
+
+	// one token is added after 2 seconds
+	if s = l.Allow("ip:192.0.2.1", rate, now.Add(2*time.Second)); !s.Allowed || s.Remaining != 0 {
+		t.Errorf("expected a refilled token, got %+v", s)
+	}
+	// the bucket never holds more than Burst tokens
+	if s = l.Allow("ip:192.0.2.1", rate, now.Add(time.Hour)); !s.Allowed || s.Remaining != 2 || s.Reset != 2 {
+		t.Errorf("expected a full bucket, got %+v", s)
+	}
+}
+
+func TestLimiterEvict(t *testing.T) {
+	l := NewLimiter()
+	rate := Rate{PerSecond: 1, Burst: 10}
+	now := time.Now()
+	l.Allow("a", rate, now)
+	for i := 0; i < 5; i++ {
+		l.Allow("b", rate, now)
+	}
+
+	l.Evict(now.Add(2 * time.Second))
+	if l.Len() != 1 {
+		t.Errorf("expected only the refilled bucket to be evicted, %d left", l.Len())
+	}
+	l.Evict(now.Add(5 * time.Second))
+	if l.Len() != 0 {
+		t.Errorf("expected all buckets to be evicted, %d left", l.Len())
+	}
+}
+
+func TestLimiterAllowAllTakesTokensOnlyIfAllAllow(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+	ip := Limit{Key: "ip:192.0.2.1", Rate: Rate{PerSecond: 1, Burst: 5}}
+	user := Limit{Key: "user:jan", Rate: Rate{PerSecond: 0.1, Burst: 1}}
+
+	statuses := l.AllowAll([]Limit{ip, user}, now)
+	if !statuses[0].Allowed || !statuses[1].Allowed || statuses[0].Remaining != 4 || statuses[1].Remaining != 0 {
+		t.Fatalf("expected the first request to be allowed, got %+v", statuses)
+	}
+	for i := 0; i < 3; i++ {
+		statuses = l.AllowAll([]Limit{ip, user}, now)
+		if statuses[0].Allowed || statuses[1].Allowed {
+			t.Fatalf("expected the request to be denied by the user limit, got %+v", statuses)
+		}
+	}
+	// the denied requests have not drained the ip bucket
+	if s := l.Allow(ip.Key, ip.Rate, now); !s.Allowed || s.Remaining != 3 {
+		t.Errorf("expected 3 remaining requests from the ip, got %+v", s)
+	}
+	if statuses[1].Reset != 10 {
+		t.Errorf("expected the user limit to reset in 10 seconds, got %+v", statuses[1])
+	}
+}
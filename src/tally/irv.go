@@ -0,0 +1,143 @@
+// Package tally contains the vote counting methods used for ranked ballots.
+// A ballot is a list of option ids ordered from the most to the least preferred one.
+package tally
+
+type CandidateTally struct {
+	OptionId int `json:"option_id"`
+	Votes    int `json:"votes"`
+}
+
+// Transfer describes where the ballots of an eliminated candidate went. To = 0 means the ballots got exhausted.
+type Transfer struct {
+	To    int `json:"to"`
+	Votes int `json:"votes"`
+}
+
+type IRVRound struct {
+	Tallies []CandidateTally `json:"tallies"`
+	// The number of ballots without any continuing candidate
+	Exhausted  int        `json:"exhausted"`
+	Eliminated int        `json:"eliminated,omitempty"`
+	Transfers  []Transfer `json:"transfers,omitempty"`
+}
+
+type IRVResult struct {
+	Rounds           []IRVRound `json:"rounds"`
+	EliminationOrder []int      `json:"elimination_order"`
+	// 0 if there is no winner, e.g. when there are no ballots
+	Winner int `json:"winner"`
+}
+
+// InstantRunoff counts the ballots with the instant-runoff method. In every round the ballots count towards
+// their highest ranked continuing candidate. A candidate with more than half of the non-exhausted ballots wins,
+// otherwise the one with the fewest votes is eliminated. Ties in elimination are broken by the tallies from
+// the previous rounds (the one that had fewer votes most recently goes out) and then by the order of candidates,
+// the later one being eliminated.
+func InstantRunoff(candidates []int, ballots [][]int) IRVResult {
+	result := IRVResult{Rounds: make([]IRVRound, 0), EliminationOrder: make([]int, 0)}
+	continuing := make(map[int]bool)
+	for _, c := range candidates {
+		continuing[c] = true
+	}
+	// the index of the current preference of every ballot, len(ballot) if exhausted
+	current := make([]int, len(ballots))
+	for i := range ballots {
+		current[i] = nextPreference(ballots[i], -1, continuing)
+	}
+	history := make([]map[int]int, 0)
+
+	for len(continuing) > 0 {
+		votes := make(map[int]int)
+		round := IRVRound{}
+		for i, b := range ballots {
+			if current[i] < len(b) {
+				votes[b[current[i]]]++
+			} else {
+				round.Exhausted++
+			}
+		}
+		active := len(ballots) - round.Exhausted
+		for _, c := range candidates {
+			if continuing[c] {
+				round.Tallies = append(round.Tallies, CandidateTally{OptionId: c, Votes: votes[c]})
+			}
+		}
+		history = append(history, votes)
+
+		if active == 0 {
+			result.Rounds = append(result.Rounds, round)
+			return result
+		}
+		for _, t := range round.Tallies {
+			if t.Votes*2 > active || len(continuing) == 1 {
+				result.Winner = t.OptionId
+				result.Rounds = append(result.Rounds, round)
+				return result
+			}
+		}
+
+		loser := pickLoser(candidates, continuing, history)
+		delete(continuing, loser)
+		round.Eliminated = loser
+		result.EliminationOrder = append(result.EliminationOrder, loser)
+		transfers := make(map[int]int)
+		for i, b := range ballots {
+			if current[i] < len(b) && b[current[i]] == loser {
+				current[i] = nextPreference(b, current[i], continuing)
+				if current[i] < len(b) {
+					transfers[b[current[i]]]++
+				} else {
+					transfers[0]++
+				}
+			}
+		}
+		round.Transfers = sortedTransfers(candidates, transfers)
+		result.Rounds = append(result.Rounds, round)
+	}
+	return result
+}
+
+func nextPreference(ballot []int, from int, continuing map[int]bool) int {
+	i := from + 1
+	for i < len(ballot) && !continuing[ballot[i]] {
+		i++
+	}
+	return i
+}
+
+// pickLoser returns the continuing candidate with the fewest votes in the last round, see InstantRunoff for tie-breaking.
+func pickLoser(candidates []int, continuing map[int]bool, history []map[int]int) int {
+	loser := 0
+	for i := len(candidates) - 1; i >= 0; i-- {
+		c := candidates[i]
+		if !continuing[c] {
+			continue
+		}
+		if loser == 0 || hasFewerVotes(c, loser, history) {
+			loser = c
+		}
+	}
+	return loser
+}
+
+func hasFewerVotes(a int, b int, history []map[int]int) bool {
+	for r := len(history) - 1; r >= 0; r-- {
+		if history[r][a] != history[r][b] {
+			return history[r][a] < history[r][b]
+		}
+	}
+	return false
+}
+
+func sortedTransfers(candidates []int, transfers map[int]int) []Transfer {
+	res := make([]Transfer, 0, len(transfers))
+	for _, c := range candidates {
+		if v, ok := transfers[c]; ok {
+			res = append(res, Transfer{To: c, Votes: v})
+		}
+	}
+	if v, ok := transfers[0]; ok {
+		res = append(res, Transfer{To: 0, Votes: v})
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package tally
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstantRunoffMajorityInFirstRound(t *testing.T) {
+	res := InstantRunoff([]int{1, 2, 3}, [][]int{{1}, {1, 2}, {2}, {1, 3}})
+	if res.Winner != 1 {
+		t.Errorf("expected winner 1, got %d", res.Winner)
+	}
+	if len(res.Rounds) != 1 || len(res.EliminationOrder) != 0 {
+		t.Errorf("expected a single round without eliminations, got %+v", res)
+	}
+}
+
+func TestInstantRunoffTransfers(t *testing.T) {
+	ballots := [][]int{
+		{1, 3}, {1, 3}, {1},
+		{2}, {2}, {2},
+		{3, 2}, {3, 2},
+	}
+	res := InstantRunoff([]int{1, 2, 3}, ballots)
+	if res.Winner != 2 {
+		t.Errorf("expected winner 2, got %d", res.Winner)
+	}
+	if !reflect.DeepEqual(res.EliminationOrder, []int{3}) {
+		t.Errorf("expected elimination order [3], got %v", res.EliminationOrder)
+	}
+	expected := []Transfer{{To: 2, Votes: 2}}
+	if !reflect.DeepEqual(res.Rounds[0].Transfers, expected) {
+		t.Errorf("expected transfers %v, got %v", expected, res.Rounds[0].Transfers)
+	}
+	if res.Rounds[1].Tallies[0].Votes != 3 || res.Rounds[1].Tallies[1].Votes != 5 {
+		t.Errorf("unexpected second round tallies %v", res.Rounds[1].Tallies)
+	}
+}
+
+func TestInstantRunoffExhaustedBallots(t *testing.T) {
+	ballots := [][]int{{1}, {1}, {2}, {2}, {3}}
+	res := InstantRunoff([]int{1, 2, 3}, ballots)
+	// 3 goes out and its ballot gets exhausted, then 2 loses the tie to 1 as the later candidate
+	if res.Rounds[0].Eliminated != 3 || res.Rounds[0].Transfers[0].To != 0 {
+		t.Errorf("unexpected first round %+v", res.Rounds[0])
+	}
+	if res.Rounds[1].Exhausted != 1 || res.Rounds[1].Eliminated != 2 {
+		t.Errorf("unexpected second round %+v", res.Rounds[1])
+	}
+	if res.Winner != 1 {
+		t.Errorf("expected winner 1, got %d", res.Winner)
+	}
+}
+
+func TestInstantRunoffTieBreakByPreviousRounds(t *testing.T) {
+	ballots := [][]int{
+		{1}, {1}, {1},
+		{2}, {2}, {2}, {2},
+		{3}, {3},
+		{4, 1},
+	}
+	res := InstantRunoff([]int{1, 2, 3, 4}, ballots)
+	// after 4 goes out, 1 has 4 votes and 2 has 4 votes, 3 has the fewest
+	if !reflect.DeepEqual(res.EliminationOrder[:2], []int{4, 3}) {
+		t.Errorf("unexpected elimination order %v", res.EliminationOrder)
+	}
+}
+
+func TestInstantRunoffNoBallots(t *testing.T) {
+	res := InstantRunoff([]int{1, 2}, nil)
+	if res.Winner != 0 || len(res.Rounds) != 1 {
+		t.Errorf("expected no winner after a single round, got %+v", res)
+	}
+}
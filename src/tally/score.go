@@ -0,0 +1,46 @@
+package tally
+
+import "sort"
+
+type ScoreBucket struct {
+	Score int `json:"score"`
+	Count int `json:"count"`
+}
+
+type ScoreStats struct {
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	// One bucket for every score from the scale, including the unused ones
+	Histogram []ScoreBucket `json:"histogram"`
+}
+
+// ScoreStatistics summarises the scores given to a single option on the scale [min, max].
+// Scores outside the scale are ignored.
+func ScoreStatistics(scores []int, min int, max int) ScoreStats {
+	stats := ScoreStats{Histogram: make([]ScoreBucket, 0, max-min+1)}
+	for s := min; s <= max; s++ {
+		stats.Histogram = append(stats.Histogram, ScoreBucket{Score: s})
+	}
+	valid := make([]int, 0, len(scores))
+	sum := 0
+	for _, s := range scores {
+		if s < min || s > max {
+			continue
+		}
+		stats.Histogram[s-min].Count++
+		valid = append(valid, s)
+		sum += s
+	}
+	if len(valid) == 0 {
+		return stats
+	}
+
+	sort.Ints(valid)
+	stats.Mean = float64(sum) / float64(len(valid))
+	if mid := len(valid) / 2; len(valid)%2 == 1 {
+		stats.Median = float64(valid[mid])
+	} else {
+		stats.Median = float64(valid[mid-1]+valid[mid]) / 2
+	}
+	return stats
+}
@@ -0,0 +1,31 @@
+package tally
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScoreStatistics(t *testing.T) {
+	stats := ScoreStatistics([]int{5, 1, 4, 4, 7}, 1, 5)
+	if stats.Mean != 3.5 || stats.Median != 4 {
+		t.Errorf("expected mean 3.5 and median 4, got %+v", stats)
+	}
+	expected := []ScoreBucket{{1, 1}, {2, 0}, {3, 0}, {4, 2}, {5, 1}}
+	if !reflect.DeepEqual(stats.Histogram, expected) {
+		t.Errorf("expected histogram %v, got %v", expected, stats.Histogram)
+	}
+}
+
+func TestScoreStatisticsEvenMedian(t *testing.T) {
+	stats := ScoreStatistics([]int{0, 3, 1, 2}, 0, 3)
+	if stats.Median != 1.5 {
+		t.Errorf("expected median 1.5, got %v", stats.Median)
+	}
+}
+
+func TestScoreStatisticsNoScores(t *testing.T) {
+	stats := ScoreStatistics(nil, 1, 3)
+	if stats.Mean != 0 || stats.Median != 0 || len(stats.Histogram) != 3 {
+		t.Errorf("expected empty statistics, got %+v", stats)
+	}
+}
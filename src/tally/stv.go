@@ -0,0 +1,217 @@
+package tally
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// Vote values are kept in fixed point with six decimal places so that the count does not depend on
+// floating point rounding. Fractions below the precision are truncated and lost.
+const stvScale = 1000000
+
+type STVCandidateTally struct {
+	OptionId int     `json:"option_id"`
+	Votes    float64 `json:"votes"`
+}
+
+// STVTransfer describes where the votes moved after an election or elimination. To = 0 means the votes got exhausted.
+type STVTransfer struct {
+	To    int     `json:"to"`
+	Votes float64 `json:"votes"`
+}
+
+type STVRound struct {
+	Tallies []STVCandidateTally `json:"tallies"`
+	// The value of the ballots without any continuing candidate
+	Exhausted  float64       `json:"exhausted"`
+	Elected    []int         `json:"elected,omitempty"`
+	Surplus    float64       `json:"surplus,omitempty"`
+	Eliminated int           `json:"eliminated,omitempty"`
+	Transfers  []STVTransfer `json:"transfers,omitempty"`
+}
+
+type STVResult struct {
+	Seats int     `json:"seats"`
+	Quota float64 `json:"quota"`
+	// Used to order the candidates that cannot be told apart by their votes, see SingleTransferableVote
+	TieBreakSeed     int64      `json:"tie_break_seed"`
+	Rounds           []STVRound `json:"rounds"`
+	Elected          []int      `json:"elected"`
+	EliminationOrder []int      `json:"elimination_order"`
+}
+
+type stvBallot struct {
+	preferences []int
+	// the index of the current preference, len(preferences) if exhausted
+	current int
+	weight  uint64
+}
+
+// SingleTransferableVote fills the seats using the Droop quota, floor(valid ballots / (seats + 1)) + 1.
+// In every round the continuing candidate with the most votes is elected if they reach the quota and their
+// surplus is transferred to the next preferences of all their ballots at a fractional value (surplus / votes).
+// Otherwise the candidate with the fewest votes is eliminated and their ballots are transferred at their
+// current value. When the number of continuing candidates equals the number of free seats, all of them are elected.
+//
+// Ties are broken by the votes in the previous rounds, starting from the most recent one, and then by the
+// SHA-256 hash of "<seed>:<option id>" - the candidate with the lower hash is preferred. Using the poll id
+// as the seed makes the result reproducible by anyone with access to the ballots.
+func SingleTransferableVote(candidates []int, ballots [][]int, seats int, seed int64) STVResult {
+	result := STVResult{
+		Seats:            seats,
+		TieBreakSeed:     seed,
+		Rounds:           make([]STVRound, 0),
+		Elected:          make([]int, 0),
+		EliminationOrder: make([]int, 0),
+	}
+	continuing := make(map[int]bool)
+	for _, c := range candidates {
+		continuing[c] = true
+	}
+	var valid uint64
+	bs := make([]stvBallot, len(ballots))
+	for i, b := range ballots {
+		bs[i] = stvBallot{preferences: b, current: nextPreference(b, -1, continuing), weight: stvScale}
+		if bs[i].current < len(b) {
+			valid++
+		}
+	}
+	quota := (valid/uint64(seats+1) + 1) * stvScale
+	result.Quota = toVotes(quota)
+	order := tieBreakOrder(candidates, seed)
+	history := make([]map[int]uint64, 0)
+
+	for len(result.Elected) < seats && len(continuing) > 0 {
+		votes := make(map[int]uint64)
+		round := STVRound{}
+		var exhausted uint64
+		for _, b := range bs {
+			if b.current < len(b.preferences) {
+				votes[b.preferences[b.current]] += b.weight
+			} else {
+				exhausted += b.weight
+			}
+		}
+		round.Exhausted = toVotes(exhausted)
+		for _, c := range candidates {
+			if continuing[c] {
+				round.Tallies = append(round.Tallies, STVCandidateTally{OptionId: c, Votes: toVotes(votes[c])})
+			}
+		}
+		history = append(history, votes)
+		better := func(a int, b int) bool {
+			for r := len(history) - 1; r >= 0; r-- {
+				if history[r][a] != history[r][b] {
+					return history[r][a] > history[r][b]
+				}
+			}
+			return order[a] < order[b]
+		}
+		ranking := make([]int, 0, len(continuing))
+		for _, c := range candidates {
+			if continuing[c] {
+				ranking = append(ranking, c)
+			}
+		}
+		sort.Slice(ranking, func(i, j int) bool { return better(ranking[i], ranking[j]) })
+
+		if valid == 0 {
+			result.Rounds = append(result.Rounds, round)
+			return result
+		}
+
+		free := seats - len(result.Elected)
+		var transfers map[int]uint64
+		if best := ranking[0]; votes[best] >= quota {
+			surplus := votes[best] - quota
+			round.Elected = []int{best}
+			round.Surplus = toVotes(surplus)
+			result.Elected = append(result.Elected, best)
+			delete(continuing, best)
+			transfers = transferBallots(bs, best, continuing, surplus, votes[best])
+		} else if len(ranking) <= free {
+			round.Elected = ranking
+			result.Elected = append(result.Elected, ranking...)
+			result.Rounds = append(result.Rounds, round)
+			return result
+		} else {
+			loser := ranking[len(ranking)-1]
+			round.Eliminated = loser
+			result.EliminationOrder = append(result.EliminationOrder, loser)
+			delete(continuing, loser)
+			transfers = transferBallots(bs, loser, continuing, 1, 1)
+		}
+		round.Transfers = sortedSTVTransfers(candidates, transfers)
+		result.Rounds = append(result.Rounds, round)
+	}
+	return result
+}
+
+// transferBallots moves the ballots of the given candidate to their next continuing preferences
+// and multiplies their values by num/den.
+func transferBallots(bs []stvBallot, from int, continuing map[int]bool, num uint64, den uint64) map[int]uint64 {
+	transfers := make(map[int]uint64)
+	for i := range bs {
+		b := &bs[i]
+		if b.current >= len(b.preferences) || b.preferences[b.current] != from {
+			continue
+		}
+		b.weight = mulDiv(b.weight, num, den)
+		b.current = nextPreference(b.preferences, b.current, continuing)
+		if b.current < len(b.preferences) {
+			transfers[b.preferences[b.current]] += b.weight
+		} else {
+			transfers[0] += b.weight
+		}
+	}
+	return transfers
+}
+
+func mulDiv(a uint64, b uint64, c uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	q, _ := bits.Div64(hi, lo, c)
+	return q
+}
+
+// tieBreakOrder assigns every candidate its position in the seeded order used as the last resort in tie-breaking.
+func tieBreakOrder(candidates []int, seed int64) map[int]int {
+	hashes := make(map[int][sha256.Size]byte)
+	sorted := make([]int, len(candidates))
+	for i, c := range candidates {
+		hashes[c] = sha256.Sum256([]byte(strconv.FormatInt(seed, 10) + ":" + strconv.Itoa(c)))
+		sorted[i] = c
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := hashes[sorted[i]], hashes[sorted[j]]
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return sorted[i] < sorted[j]
+	})
+	order := make(map[int]int)
+	for i, c := range sorted {
+		order[c] = i
+	}
+	return order
+}
+
+func sortedSTVTransfers(candidates []int, transfers map[int]uint64) []STVTransfer {
+	res := make([]STVTransfer, 0, len(transfers))
+	for _, c := range candidates {
+		if v, ok := transfers[c]; ok && v > 0 {
+			res = append(res, STVTransfer{To: c, Votes: toVotes(v)})
+		}
+	}
+	if v, ok := transfers[0]; ok && v > 0 {
+		res = append(res, STVTransfer{To: 0, Votes: toVotes(v)})
+	}
+	return res
+}
+
+func toVotes(v uint64) float64 {
+	return float64(v) / stvScale
+}
@@ -0,0 +1,97 @@
+package tally
+
+import (
+	"reflect"
+	"testing"
+)
+
+func repeatBallot(ballot []int, n int) [][]int {
+	res := make([][]int, n)
+	for i := range res {
+		res[i] = ballot
+	}
+	return res
+}
+
+func TestSingleTransferableVoteSurplusTransfer(t *testing.T) {
+	ballots := repeatBallot([]int{1, 2}, 6)
+	ballots = append(ballots, repeatBallot([]int{3}, 2)...)
+	ballots = append(ballots, []int{2})
+
+	res := SingleTransferableVote([]int{1, 2, 3}, ballots, 2, 1)
+	if res.Quota != 4 {
+		t.Errorf("expected quota 4, got %v", res.Quota)
+	}
+	if !reflect.DeepEqual(res.Elected, []int{1, 2}) {
+		t.Errorf("expected elected [1 2], got %v", res.Elected)
+	}
+	first := res.Rounds[0]
+	if !reflect.DeepEqual(first.Elected, []int{1}) || first.Surplus != 2 {
+		t.Errorf("unexpected first round %+v", first)
+	}
+	// 6 ballots at 2/6 each, truncated to six decimal places
+	if !reflect.DeepEqual(first.Transfers, []STVTransfer{{To: 2, Votes: 1.999998}}) {
+		t.Errorf("unexpected surplus transfers %v", first.Transfers)
+	}
+	if res.Rounds[1].Eliminated != 3 || res.Rounds[1].Transfers[0].To != 0 {
+		t.Errorf("expected 3 to be eliminated with exhausted ballots, got %+v", res.Rounds[1])
+	}
+	if !reflect.DeepEqual(res.EliminationOrder, []int{3}) {
+		t.Errorf("unexpected elimination order %v", res.EliminationOrder)
+	}
+}
+
+func TestSingleTransferableVoteEliminationTransfers(t *testing.T) {
+	ballots := repeatBallot([]int{1}, 4)
+	ballots = append(ballots, repeatBallot([]int{2}, 3)...)
+	ballots = append(ballots, repeatBallot([]int{3, 2}, 2)...)
+	ballots = append(ballots, repeatBallot([]int{4}, 3)...)
+
+	// quota: 12 / 3 + 1 = 5, nobody reaches it until 3 goes out
+	res := SingleTransferableVote([]int{1, 2, 3, 4}, ballots, 2, 1)
+	if res.Rounds[0].Eliminated != 3 {
+		t.Errorf("expected 3 to be eliminated first, got %+v", res.Rounds[0])
+	}
+	if !reflect.DeepEqual(res.Rounds[1].Elected, []int{2}) {
+		t.Errorf("expected 2 to be elected in the second round, got %+v", res.Rounds[1])
+	}
+	if !reflect.DeepEqual(res.Elected, []int{2, 1}) {
+		t.Errorf("expected elected [2 1], got %v", res.Elected)
+	}
+}
+
+func TestSingleTransferableVoteFillsRemainingSeats(t *testing.T) {
+	res := SingleTransferableVote([]int{1, 2, 3}, [][]int{{1}, {2}, {3}, {1}}, 3, 1)
+	if len(res.Elected) != 3 {
+		t.Errorf("expected all candidates to be elected, got %v", res.Elected)
+	}
+}
+
+func TestSingleTransferableVoteTieBreakIsReproducible(t *testing.T) {
+	candidates := []int{1, 2, 3, 4}
+	ballots := [][]int{{1}, {2}, {3}, {4}}
+	order := tieBreakOrder(candidates, 42)
+	var expected int
+	for _, c := range candidates {
+		if order[c] == len(candidates)-1 {
+			expected = c
+		}
+	}
+
+	first := SingleTransferableVote(candidates, ballots, 1, 42)
+	if first.EliminationOrder[0] != expected {
+		t.Errorf("expected %d to lose the tie, got %v", expected, first.EliminationOrder)
+	}
+	for i := 0; i < 10; i++ {
+		if res := SingleTransferableVote(candidates, ballots, 1, 42); !reflect.DeepEqual(res, first) {
+			t.Fatalf("the result is not reproducible: %+v vs %+v", res, first)
+		}
+	}
+}
+
+func TestSingleTransferableVoteNoBallots(t *testing.T) {
+	res := SingleTransferableVote([]int{1, 2, 3}, nil, 2, 1)
+	if len(res.Elected) != 0 || len(res.Rounds) != 1 {
+		t.Errorf("expected nobody to be elected after a single round, got %+v", res)
+	}
+}
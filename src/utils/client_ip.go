@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIpKey struct{}
+
+// TrustedProxies are the networks of the reverse proxies allowed to pass the client address in the
+// Forwarded, X-Forwarded-For and X-Real-IP headers.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of CIDRs. Single addresses are accepted as well.
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(list))
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (t TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIp returns the address of the client. The forwarding headers are only read from trusted proxies.
+// The chain of forwarded addresses is walked from the nearest hop and the first address which is not
+// a trusted proxy is the client.
+func (t TrustedProxies) ClientIp(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil || !t.Contains(ip) {
+		return remote
+	}
+
+	chain := forwardedFor(r.Header.Values("Forwarded"))
+	if len(chain) == 0 {
+		chain = splitList(r.Header.Values("X-Forwarded-For"))
+	}
+	if len(chain) == 0 {
+		chain = splitList(r.Header.Values("X-Real-IP"))
+	}
+	client := ip
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := parseHop(chain[i])
+		if hop == nil {
+			// unknown or obfuscated identifiers cannot be followed any further
+			break
+		}
+		client = hop
+		if !t.Contains(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for= parameters of the RFC 7239 Forwarded headers.
+func forwardedFor(headers []string) []string {
+	chain := make([]string, 0)
+	for _, element := range splitList(headers) {
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				chain = append(chain, strings.Trim(kv[1], `"`))
+			}
+		}
+	}
+	return chain
+}
+
+func splitList(headers []string) []string {
+	list := make([]string, 0)
+	for _, h := range headers {
+		for _, v := range strings.Split(h, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+// parseHop parses an address optionally followed by a port, with IPv6 addresses possibly in brackets.
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
+
+// WithClientIp returns a copy of the context carrying the client address.
+func WithClientIp(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIpKey{}, ip)
+}
+
+// GetClientIp returns the client address stored in the request context, falling back to the address
+// the request came from.
+func GetClientIp(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIpKey{}).(string); ok {
+		return ip
+	}
+	return TrustedProxies(nil).ClientIp(r)
+}
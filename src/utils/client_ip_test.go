@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted peer spoofing", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "192.0.2.1"},
+		{"trusted without headers", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"x-forwarded-for", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"spoofed chain", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7, 10.0.0.3"}, "198.51.100.7"},
+		{"x-real-ip", "10.0.0.2:1234", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		{"forwarded", "10.0.0.2:1234", map[string]string{"Forwarded": `for=198.51.100.7;proto=https, for="[2001:db8::1]:4711"`, "X-Forwarded-For": "203.0.113.9"}, "198.51.100.7"},
+		{"forwarded ipv6", "[2001:db8::1]:443", map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"obfuscated", "10.0.0.2:1234", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.2"},
+		{"all trusted", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := proxies.ClientIp(r); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestParseTrustedProxiesRejectsInvalidEntries(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("expected an error for an invalid CIDR")
+	}
+	if _, err := ParseTrustedProxies([]string{"nginx"}); err == nil {
+		t.Errorf("expected an error for a host name")
+	}
+}
+
+func TestGetClientIpFromContext(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := GetClientIp(r); got != "192.0.2.1" {
+		t.Errorf("expected the remote address without a context value, got %s", got)
+	}
+	r = r.WithContext(WithClientIp(r.Context(), "198.51.100.7"))
+	if got := GetClientIp(r); got != "198.51.100.7" {
+		t.Errorf("expected the address from the context, got %s", got)
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// SignOptOutToken returns the token put in the opt-out link of the emails sent to the address.
+func SignOptOutToken(secret string, email string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(email)) + "." + base64.RawURLEncoding.EncodeToString(optOutMac(secret, email))
+}
+
+// VerifyOptOutToken checks the signature of the token and returns the address it was issued for.
+func VerifyOptOutToken(secret string, token string) (string, error) {
+	if secret == "" {
+		return "", errors.New("opting out is disabled")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errors.New("malformed opt-out token")
+	}
+	email, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.New("malformed opt-out token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, optOutMac(secret, string(email))) {
+		return "", errors.New("invalid opt-out token signature")
+	}
+	return string(email), nil
+}
+
+func optOutMac(secret string, email string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("opt-out:" + email))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestOptOutToken(t *testing.T) {
+	token := SignOptOutToken("secret", "jan.kowalski@example.com")
+	email, err := VerifyOptOutToken("secret", token)
+	if err != nil || email != "jan.kowalski@example.com" {
+		t.Fatalf("expected the token to verify, got %q (err: %v)", email, err)
+	}
+	if _, err = VerifyOptOutToken("other-secret", token); err == nil {
+		t.Errorf("the token verified with a different secret")
+	}
+	if _, err = VerifyOptOutToken("", token); err == nil {
+		t.Errorf("the token verified with opting out disabled")
+	}
+
+	// the signature of one address must not work for another one
+	signature := token[strings.Index(token, ".")+1:]
+	forged := base64.RawURLEncoding.EncodeToString([]byte("anna.nowak@example.com")) + "." + signature
+	if _, err = VerifyOptOutToken("secret", forged); err == nil {
+		t.Errorf("the signature verified for a different address")
+	}
+	for _, malformed := range []string{"", "abc", "a.b.c", "!!!.abc"} {
+		if _, err = VerifyOptOutToken("secret", malformed); err == nil {
+			t.Errorf("the malformed token %q verified", malformed)
+		}
+	}
+}
@@ -2,47 +2,23 @@ package utils
 
 import (
 	"bytes"
-	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"github.com/badoux/checkmail"
 	"github.com/google/uuid"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
-	"net/smtp"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"switch-polls-backend/config"
+	texttemplate "text/template"
 )
 
 type InvalidJson struct{}
 
-type EmailTemplateValues struct {
-	Receiver    string
-	ServiceName string
-	VoteOption  string
-	PollTitle   string
-	PollId      string
-	Link        string
-}
-
-type RecaptchaVerifyRequest struct {
-	Secret   string `json:"secret"`
-	Response string `json:"response"`
-	RemoteIp string `json:"remoteip"`
-}
-
-type RecaptchaVerifyResponse struct {
-	Success     bool     `json:"success"`
-	ChallengeTs string   `json:"challenge_ts"`
-	Hostname    string   `json:"hostname"`
-	Action      string   `json:"action"`
-	Score       float32  `json:"score"`
-	ErrorCodes  []string `json:"error-codes"`
-}
+type EmailTemplateValues = config.EmailTemplateValues
 
 func (e *InvalidJson) Error() string {
 	return "JSON is not valid"
@@ -98,24 +74,16 @@ func ValidateEmail(email string) error {
 	return checkmail.ValidateFormat(email)
 }
 
-func SendEmail(conf *config.EmailConfiguration, subject string, msg string, receiver string) error {
-	msg = "Subject: " + subject + "\nFrom: " + conf.SenderEmail + "\nMIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n" + msg
-	receiversList := []string{receiver}
-
-	auth := smtp.PlainAuth("", conf.SenderMailboxUsername, conf.SenderEmailPasswd, conf.SmtpHost)
-	err := smtp.SendMail(conf.SmtpHost+":"+strconv.Itoa(conf.SmtpPort), auth, conf.SenderEmail, receiversList, []byte(msg))
-	return err
-}
-
 func GetNewToken() string {
 	return uuid.NewString()
 }
 
-func FillEmailTemplate(contents EmailTemplateValues) string {
+// FillEmailTemplate renders the HTML part of an email. The values are escaped for HTML.
+func FillEmailTemplate(emailTemplate string, contents EmailTemplateValues) string {
 	var err error
 
 	temp := template.New("email_temp")
-	temp, err = temp.Parse(config.Cfg.EmailConfig.EmailTemplate)
+	temp, err = temp.Parse(emailTemplate)
 	if err != nil {
 		log.Printf("template parse error: %s\n", err)
 		return ""
@@ -129,43 +97,34 @@ func FillEmailTemplate(contents EmailTemplateValues) string {
 	return buf.String()
 }
 
-func VerifyRecaptcha(ctx *context.Context, rq *http.Request) bool {
-	origin := rq.Header.Get("Origin")
-	token := rq.Header.Get("g-recaptcha-response")
-	if len(token) == 0 || len(token) > 1024 || len(origin) > 384 || !IsAlphaWithDashAndUnderscore(token) {
-		log.Printf("Required captcha header not found")
-		return false
-	}
-	data := url.Values{}
-	data.Set("secret", config.Cfg.WebConfig.RecaptchaSecret)
-	data.Set("response", token)
-	data.Set("remoteip", rq.RemoteAddr)
-
-	res, err := http.Post(
-		config.Cfg.WebConfig.RecaptchaVerifyEndpoint,
-		"application/x-www-form-urlencoded",
-		strings.NewReader(data.Encode()))
+// FillTextEmailTemplate renders the plain-text part of an email from the same values as FillEmailTemplate.
+func FillTextEmailTemplate(emailTemplate string, contents EmailTemplateValues) string {
+	temp, err := texttemplate.New("email_text_temp").Parse(emailTemplate)
 	if err != nil {
-		log.Printf("ReCAPTCHA verification error: %v", err)
-		return false
+		log.Printf("text template parse error: %s\n", err)
+		return ""
 	}
-	bodyResp, err := io.ReadAll(res.Body)
+	var buf bytes.Buffer
+	err = temp.Execute(&buf, &contents)
 	if err != nil {
-		log.Printf("ReCAPTCHA verification error: %v", err)
-		return false
+		log.Printf("text template execute error: %s\n", err)
+		return ""
 	}
+	return buf.String()
+}
 
-	var resp RecaptchaVerifyResponse
-	err = json.Unmarshal(bodyResp, &resp)
-	if err != nil {
-		log.Printf("ReCAPTCHA verification error: %v", err)
+// VerifyAdminApiKey checks whether the request carries one of the configured admin keys in the Authorization header.
+func VerifyAdminApiKey(rq *http.Request) bool {
+	const prefix = "Bearer "
+	header := rq.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
 		return false
 	}
-	*ctx = context.WithValue(*ctx, "recaptcha", resp)
-
-	if !resp.Success || resp.Score < config.Cfg.WebConfig.RecaptchaMinScore {
-		log.Printf("Request from %s failed ReCAPTCHA verification (score: %.3f; errors: %v)", rq.RemoteAddr, resp.Score, resp.ErrorCodes)
-		return false
+	key := []byte(strings.TrimPrefix(header, prefix))
+	for _, allowed := range config.Cfg.WebConfig.AdminApiKeys {
+		if len(allowed) > 0 && subtle.ConstantTimeCompare(key, []byte(allowed)) == 1 {
+			return true
+		}
 	}
-	return true
+	return false
 }
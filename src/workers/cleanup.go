@@ -0,0 +1,46 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"switch-polls-backend/config"
+	"switch-polls-backend/db"
+	"time"
+)
+
+// RunCleanup periodically removes expired confirmation tokens, the old sent and dead outbox emails, stale
+// unconfirmed votes and the users who have never confirmed any vote.
+func RunCleanup(ctx context.Context) {
+	cfg := config.Cfg.WorkersConfig
+	interval := time.Duration(cfg.CleanupInterval) * time.Second
+	RunPeriodically(ctx, "cleanup", interval, func(now time.Time) {
+		var confirmations, emails, votes, users int64
+		var err error
+		if ttl := config.Cfg.WebConfig.ConfirmationTokenTTL; ttl > 0 {
+			if confirmations, err = db.DeleteExpiredConfirmations(ttl); err != nil {
+				log.Printf("Cleanup failed: %v", err)
+				return
+			}
+		}
+		if retention := cfg.OutboxRetention; retention > 0 {
+			if emails, err = db.DeleteOldOutboxEmails(retention); err != nil {
+				log.Printf("Cleanup failed: %v", err)
+				return
+			}
+		}
+		if retention := cfg.UnconfirmedVoteRetention; retention > 0 {
+			if votes, err = db.DeleteUnconfirmedVotes(retention); err != nil {
+				log.Printf("Cleanup failed: %v", err)
+				return
+			}
+		}
+		if retention := cfg.InactiveUserRetention; retention > 0 {
+			if users, err = db.DeleteInactiveUsers(retention); err != nil {
+				log.Printf("Cleanup failed: %v", err)
+				return
+			}
+		}
+		log.Printf("Cleanup removed %d expired confirmation(s), %d outbox email(s), %d unconfirmed vote(s) and %d inactive user(s).",
+			confirmations, emails, votes, users)
+	})
+}
@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"switch-polls-backend/config"
+	"switch-polls-backend/db"
+	"switch-polls-backend/email"
+	"switch-polls-backend/utils"
+	"sync"
+	"time"
+)
+
+// RunOutbox periodically claims the emails due for delivery and sends them using a pool of workers.
+// Failed deliveries are retried with exponential backoff until MaxAttempts is reached.
+func RunOutbox(ctx context.Context, mailer email.Mailer) {
+	cfg := config.Cfg.WorkersConfig.Outbox
+	interval := time.Duration(cfg.PollInterval) * time.Second
+	RunPeriodically(ctx, "email outbox", interval, func(now time.Time) {
+		emails, err := db.OutboxRepo.Claim(utils.GetNewToken(), cfg.BatchSize, cfg.ClaimTimeout, cfg.MaxAttempts)
+		if err != nil {
+			log.Printf("Email outbox failed to claim emails: %v", err)
+			return
+		}
+		if len(emails) == 0 {
+			return
+		}
+
+		workers := cfg.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		queue := make(chan db.OutboxEmail)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for e := range queue {
+					deliverEmail(mailer, cfg, e)
+				}
+			}()
+		}
+		for _, e := range emails {
+			queue <- e
+		}
+		close(queue)
+		wg.Wait()
+	})
+}
+
+func deliverEmail(mailer email.Mailer, cfg config.OutboxConfiguration, e db.OutboxEmail) {
+	sendErr := email.Send(mailer, &config.Cfg.EmailConfig, email.Message{
+		To:      e.Recipient,
+		Subject: e.Subject,
+		Text:    e.TextBody,
+		HTML:    e.Body,
+	})
+	if sendErr == nil {
+		if err := db.OutboxRepo.MarkSent(e.Id); err != nil {
+			log.Printf("Email outbox failed to mark email %d as sent: %v", e.Id, err)
+		}
+		return
+	}
+
+	attempts := e.Attempts + 1
+	dead := attempts >= cfg.MaxAttempts
+	if dead {
+		log.Printf("Email outbox gave up on email %d to %s after %d attempts: %v", e.Id, e.Recipient, attempts, sendErr)
+	} else {
+		log.Printf("Email outbox failed to send email %d to %s (attempt %d): %v", e.Id, e.Recipient, attempts, sendErr)
+	}
+	if err := db.OutboxRepo.MarkFailed(e.Id, sendErr, Backoff(attempts, cfg.InitialBackoff, cfg.MaxBackoff), dead); err != nil {
+		log.Printf("Email outbox failed to mark email %d as failed: %v", e.Id, err)
+	}
+}
+
+// Backoff returns the delay in seconds before the next attempt after the given number of failed ones.
+func Backoff(attempts int, initial int, maxDelay int) int {
+	delay := initial
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
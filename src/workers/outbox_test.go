@@ -0,0 +1,26 @@
+package workers
+
+import "testing"
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts, initial, maxDelay, expected int
+	}{
+		{1, 30, 3600, 30},
+		{2, 30, 3600, 60},
+		{3, 30, 3600, 120},
+		{7, 30, 3600, 1920},
+		{8, 30, 3600, 3600},
+		{100, 30, 3600, 3600},
+		{0, 30, 3600, 30},
+		{5, 0, 3600, 0},
+		{3, 30, 45, 45},
+		{1, 60, 45, 45},
+		{4, 30, 0, 0},
+	}
+	for _, c := range cases {
+		if got := Backoff(c.attempts, c.initial, c.maxDelay); got != c.expected {
+			t.Errorf("Backoff(%d, %d, %d) = %d, expected %d", c.attempts, c.initial, c.maxDelay, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"switch-polls-backend/config"
+	"switch-polls-backend/db"
+	"time"
+)
+
+// RunPollScheduler periodically makes read-only the polls whose closing time has passed, so that
+// the is_readonly column stays consistent with closes_at.
+func RunPollScheduler(ctx context.Context) {
+	interval := time.Duration(config.Cfg.WorkersConfig.PollSchedulerInterval) * time.Second
+	RunPeriodically(ctx, "poll scheduler", interval, func(now time.Time) {
+		closed, err := db.PollsRepo.CloseExpiredPolls(now)
+		if err != nil {
+			log.Printf("Poll scheduler failed to close expired polls: %v", err)
+			return
+		}
+		if closed > 0 {
+			log.Printf("Poll scheduler made %d poll(s) read-only.", closed)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package workers
+
+import (
+	"context"
+	"switch-polls-backend/config"
+	"switch-polls-backend/ratelimit"
+	"time"
+)
+
+// RunRateLimitEviction periodically drops the rate limiter buckets of the clients which have been idle
+// long enough for their buckets to refill.
+func RunRateLimitEviction(ctx context.Context, limiter *ratelimit.Limiter) {
+	interval := time.Duration(config.Cfg.WorkersConfig.RateLimitEvictionInterval) * time.Second
+	RunPeriodically(ctx, "rate limiter eviction", interval, limiter.Evict)
+}
@@ -0,0 +1,29 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunPeriodically calls job every interval until the context is cancelled.
+// A non-positive interval disables the worker.
+func RunPeriodically(ctx context.Context, name string, interval time.Duration, job func(now time.Time)) {
+	if interval <= 0 {
+		log.Printf("The %s worker is disabled.", name)
+		return
+	}
+	log.Printf("Starting the %s worker (interval: %v).", name, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	job(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("The %s worker has stopped.", name)
+			return
+		case now := <-ticker.C:
+			job(now)
+		}
+	}
+}